@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
@@ -11,14 +12,26 @@ import (
 	"syscall"
 	"time"
 
+	"TeleOko/internal/auth"
 	"TeleOko/internal/config"
+	"TeleOko/internal/eventlog"
+	"TeleOko/internal/events"
 	"TeleOko/internal/go2rtc"
 	"TeleOko/internal/handlers"
+	"TeleOko/internal/hikvision"
+	"TeleOko/internal/redact"
+	"TeleOko/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Время на завершение текущих HTTP запросов
+const httpShutdownTimeout = 10 * time.Second
+
 func main() {
+	// Пароли камер в URL не должны попадать в журнал
+	log.SetOutput(redact.NewWriter(os.Stderr))
+
 	log.Println("🚀 Запуск TeleOko - Система видеонаблюдения")
 
 	// Загрузка конфигурации
@@ -41,18 +54,35 @@ func main() {
 	if config.IsGo2RTCEnabled() {
 		log.Println("🎥 Запуск go2rtc...")
 		go2rtcManager = go2rtc.NewManager()
+		// Падение и перезапуск go2rtc передаются подписчикам как системное событие
+		go2rtcManager.SetStatusHandler(func(running bool, reason string) {
+			state := hikvision.EventStateActive
+			if running {
+				state = hikvision.EventStateInactive
+			}
+			events.PublishSystem(events.TypeGo2RTCDown, state, reason)
+		})
 		if err := go2rtcManager.Start(); err != nil {
 			log.Fatalf("❌ Ошибка запуска go2rtc: %v", err)
 		}
 		log.Println("✅ go2rtc успешно запущен")
 
 		// Добавляем потоки
-		time.Sleep(3 * time.Second) // Ждем полного запуска go2rtc
-		if err := go2rtcManager.UpdateStreams(); err != nil {
+		if _, err := go2rtcManager.UpdateStreams(); err != nil {
 			log.Printf("⚠️ Ошибка обновления потоков: %v", err)
 		}
 	}
 
+	// Подписка на события устройств; останавливается вместе с веб-сервером,
+	// чтобы открытые SSE соединения не задерживали завершение
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	defer stopEvents()
+	if err := eventlog.Start(eventsCtx); err != nil {
+		log.Printf("⚠️ Журнал событий недоступен: %v", err)
+	}
+	webhook.Start(eventsCtx, fmt.Sprintf("http://%s:%d", ip, cfg.Server.Port))
+	events.Start(eventsCtx)
+
 	// Настройка Gin
 	if os.Getenv("GIN_MODE") != "debug" {
 		gin.SetMode(gin.ReleaseMode)
@@ -73,20 +103,41 @@ func main() {
 		c.Next()
 	})
 
+	// Аутентификация (cookie сессии или Basic) и права ролей
+	authEnabled := cfg.Auth.Enabled
+	authRequired := auth.SessionAuth(authEnabled)
+	canLive := auth.RequirePermission(auth.PermLive, authEnabled)
+	canArchive := auth.RequirePermission(auth.PermArchive, authEnabled)
+	canAdmin := auth.RequirePermission(auth.PermAdmin, authEnabled)
+	if authEnabled {
+		log.Println("🔒 Аутентификация включена")
+		if len(cfg.Auth.Users) == 0 {
+			log.Println("⚠️ Список пользователей пуст - используется учетная запись auth.username с правами администратора")
+		}
+		r.GET("/login", auth.LoginPage)
+		r.POST("/login", auth.Login)
+		r.POST("/logout", auth.Logout)
+	}
+
 	// Статические файлы и шаблоны
-	r.Static("/static", "./web/static")
+	r.Group("/static", authRequired).Static("/", "./web/static")
 	r.LoadHTMLGlob("web/templates/*")
 
 	// Главная страница
-	r.GET("/", func(c *gin.Context) {
+	r.GET("/", authRequired, func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index.html", gin.H{
-			"ip":       ip,
-			"channels": config.GetChannels(),
+			"ip":           ip,
+			"channels":     handlers.VisibleChannels(c),
+			"auth_enabled": authEnabled,
+			"can_archive":  handlers.CanArchive(c),
 		})
 	})
 
+	// Снимки для получателей вебхуков: без авторизации, имя случайное
+	r.GET(webhook.SnapshotPathPrefix+":name", handlers.GetWebhookSnapshot)
+
 	// API группа
-	api := r.Group("/api")
+	api := r.Group("/api", authRequired)
 	{
 		// Информация о системе
 		api.GET("/info", handlers.GetSystemInfo)
@@ -97,55 +148,124 @@ func main() {
 		})
 
 		// Работа с каналами
-		api.GET("/channels", handlers.GetChannels)
+		api.GET("/channels", canLive, handlers.GetChannels)
 
 		// Прямой эфир
-		api.GET("/stream/:channel", handlers.GetLiveStream)
-		api.POST("/webrtc/offer", handlers.HandleWebRTCOffer)
+		api.GET("/stream/:channel", canLive, handlers.GetLiveStream)
+		api.POST("/webrtc/offer", canLive, handlers.HandleWebRTCOffer)
 
 		// Архивные записи
-		api.GET("/recordings", handlers.GetRecordings)
-		api.GET("/playback-url", handlers.GetPlaybackURL)
-		api.POST("/webrtc/offer/playback", handlers.HandlePlaybackWebRTC)
+		api.GET("/recordings", canArchive, handlers.GetRecordings)
+		api.GET("/playback-url", canArchive, handlers.GetPlaybackURL)
+		api.POST("/webrtc/offer/playback", canArchive, handlers.HandlePlaybackWebRTC)
+		api.DELETE("/webrtc/playback/:id", canArchive, handlers.StopPlaybackWebRTC)
+		api.GET("/export", canArchive, handlers.ExportClip)
+
+		// Сессии воспроизведения архива: переход, пауза, скорость
+		api.POST("/playback/sessions", canArchive, handlers.CreatePlaybackSession)
+		api.GET("/playback/sessions", canArchive, handlers.ListPlaybackSessions)
+		api.GET("/playback/sessions/:id", canArchive, handlers.GetPlaybackSession)
+		api.POST("/playback/sessions/:id/offer", canArchive, handlers.PlaybackSessionOffer)
+		api.POST("/playback/sessions/:id/seek", canArchive, handlers.SeekPlaybackSession)
+		api.POST("/playback/sessions/:id/pause", canArchive, handlers.PausePlaybackSession)
+		api.POST("/playback/sessions/:id/resume", canArchive, handlers.ResumePlaybackSession)
+		api.POST("/playback/sessions/:id/speed", canArchive, handlers.SetPlaybackSessionSpeed)
+		api.DELETE("/playback/sessions/:id", canArchive, handlers.DeletePlaybackSession)
+
+		// Синхронное воспроизведение архива нескольких каналов
+		api.POST("/playback/groups", canArchive, handlers.CreatePlaybackGroup)
+		api.GET("/playback/groups", canArchive, handlers.ListPlaybackGroups)
+		api.GET("/playback/groups/:id", canArchive, handlers.GetPlaybackGroup)
+		api.POST("/playback/groups/:id/streams/:channel/offer", canArchive, handlers.PlaybackGroupOffer)
+		api.POST("/playback/groups/:id/positions", canArchive, handlers.ReportPlaybackGroupPositions)
+		api.POST("/playback/groups/:id/seek", canArchive, handlers.SeekPlaybackGroup)
+		api.POST("/playback/groups/:id/pause", canArchive, handlers.PausePlaybackGroup)
+		api.POST("/playback/groups/:id/resume", canArchive, handlers.ResumePlaybackGroup)
+		api.POST("/playback/groups/:id/speed", canArchive, handlers.SetPlaybackGroupSpeed)
+		api.DELETE("/playback/groups/:id", canArchive, handlers.DeletePlaybackGroup)
+
+		// События устройств (Server-Sent Events)
+		api.GET("/events", canLive, handlers.StreamEvents)
+		api.GET("/events/history", canArchive, handlers.GetEventHistory)
+		api.GET("/events/history/:id/snapshot", canArchive, handlers.GetEventSnapshot)
 
 		// Снимки (если понадобятся)
-		api.GET("/snapshot/:channel", handlers.GetSnapshot)
+		api.GET("/snapshot/:channel", canLive, handlers.GetSnapshot)
 
 		// Тестирование подключения к камере
-		api.GET("/test-connection", handlers.TestCameraConnection)
+		api.GET("/test-connection", canAdmin, handlers.TestCameraConnection)
 
-		// Проксирование запросов к go2rtc
-		if go2rtcManager != nil {
-			api.Any("/go2rtc/*path", handlers.ProxyToGo2RTC)
-		}
-	}
+		// Управление пользователями
+		api.GET("/users", canAdmin, handlers.ListUsers)
+		api.POST("/users", canAdmin, handlers.CreateUser)
+		api.DELETE("/users/:username", canAdmin, handlers.DeleteUser)
+		api.POST("/users/:username/password", canAdmin, handlers.ResetUserPassword)
+		api.PUT("/users/:username/channels", canAdmin, handlers.SetUserChannels)
 
-	// Обработка сигналов завершения
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		log.Println("\n🛑 Получен сигнал завершения...")
+		// Вебхуки: статистика, недоставленные события, проверка доставки
+		api.GET("/webhooks", canAdmin, handlers.ListWebhooks)
+		api.GET("/webhooks/dead-letters", canAdmin, handlers.GetWebhookDeadLetters)
+		api.POST("/webhooks/:name/test", canAdmin, handlers.TestWebhook)
 
-		// Остановка go2rtc
+		// Проксирование запросов к go2rtc
 		if go2rtcManager != nil {
-			log.Println("⏹️ Остановка go2rtc...")
-			if err := go2rtcManager.Stop(); err != nil {
-				log.Printf("⚠️ Ошибка остановки go2rtc: %v", err)
-			}
+			api.POST("/streams/sync", canAdmin, handlers.SyncStreams)
+			api.Any("/go2rtc/*path", canAdmin, handlers.ProxyToGo2RTC)
 		}
+	}
 
-		log.Println("👋 TeleOko завершен")
-		os.Exit(0)
-	}()
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler: r,
+	}
+	srv.RegisterOnShutdown(stopEvents)
 
 	// Запуск веб-сервера
 	log.Printf("🌍 Запуск веб-сервера на порту %d", cfg.Server.Port)
 	log.Printf("🔗 Откройте браузер: http://localhost:%d", cfg.Server.Port)
 	log.Printf("🔗 Или по сети: http://%s:%d", ip, cfg.Server.Port)
 
-	if err := r.Run(fmt.Sprintf(":%d", cfg.Server.Port)); err != nil {
-		log.Fatalf("❌ Ошибка запуска сервера: %v", err)
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
+		}
+	}()
+
+	// Ожидание сигнала завершения
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-quit:
+		log.Println("🛑 Получен сигнал завершения...")
+	case err := <-serverErr:
+		log.Printf("❌ Ошибка запуска сервера: %v", err)
+		shutdown(srv, go2rtcManager)
+		os.Exit(1)
+	}
+
+	shutdown(srv, go2rtcManager)
+	log.Println("👋 TeleOko завершен")
+}
+
+// shutdown по порядку останавливает веб-сервер и go2rtc
+func shutdown(srv *http.Server, go2rtcManager *go2rtc.Manager) {
+	// Сначала перестаем принимать запросы и дожидаемся текущих
+	log.Println("⏹️ Остановка веб-сервера...")
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("⚠️ Веб-сервер не завершил запросы вовремя: %v", err)
+		srv.Close()
+	}
+
+	// Затем останавливаем go2rtc, от которого зависят обработчики
+	if go2rtcManager != nil {
+		log.Println("⏹️ Остановка go2rtc...")
+		if err := go2rtcManager.Stop(); err != nil {
+			log.Printf("⚠️ Ошибка остановки go2rtc: %v", err)
+		}
 	}
 }
 
@@ -0,0 +1,85 @@
+// cmd/users/main.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"TeleOko/internal/auth"
+	"TeleOko/internal/config"
+)
+
+const usage = `Управление пользователями TeleOko
+
+Использование:
+  users list
+  users add -u <имя> -p <пароль> -r <viewer|archive-viewer|admin>
+  users remove -u <имя>
+  users reset -u <имя> -p <новый пароль>
+  users channels -u <имя> -c <101,102,...>   (пустой -c - доступ по роли)
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	username := fs.String("u", "", "имя пользователя")
+	password := fs.String("p", "", "пароль")
+	role := fs.String("r", string(auth.RoleViewer), "роль")
+	channels := fs.String("c", "", "разрешенные каналы через запятую")
+	fs.Parse(os.Args[2:])
+
+	if _, err := config.Load(); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		for _, u := range auth.ListUsers() {
+			access := "все каналы"
+			if u.Channels != nil {
+				access = strings.Join(u.Channels, ",")
+			}
+			fmt.Printf("%-20s %-16s %s\n", u.Username, u.Role, access)
+		}
+	case "add":
+		err = auth.AddUser(*username, *password, auth.Role(*role))
+		if err == nil && *channels != "" {
+			err = auth.SetUserChannels(*username, splitChannels(*channels))
+		}
+	case "channels":
+		err = auth.SetUserChannels(*username, splitChannels(*channels))
+	case "remove":
+		err = auth.RemoveUser(*username)
+	case "reset":
+		err = auth.ResetPassword(*username, *password)
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if os.Args[1] != "list" {
+		fmt.Println("✅ Готово")
+	}
+}
+
+// splitChannels разбирает список каналов через запятую
+func splitChannels(list string) []string {
+	var channels []string
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			channels = append(channels, id)
+		}
+	}
+	return channels
+}
@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -10,8 +12,24 @@ import (
 
 // User представляет пользователя системы
 type User struct {
-	Username string
-	Password string
+	Username string `json:"username"`
+	Role     Role   `json:"role"`
+
+	// Channels - разрешенные каналы, nil означает доступ ко всем
+	Channels []string `json:"channels,omitempty"`
+}
+
+// CanAccessChannel проверяет, разрешен ли пользователю канал
+func (u *User) CanAccessChannel(channelID string) bool {
+	if u.Role == RoleAdmin || u.Channels == nil {
+		return true
+	}
+	for _, id := range u.Channels {
+		if id == channelID {
+			return true
+		}
+	}
+	return false
 }
 
 // Middleware для базовой аутентификации
@@ -59,7 +77,7 @@ func BasicAuth(username, password string, enabled bool) gin.HandlerFunc {
 		}
 
 		// Проверяем учетные данные
-		if credentials[0] != username || credentials[1] != password {
+		if !validCredentials(credentials[0], credentials[1], username, password) {
 			c.Header("WWW-Authenticate", "Basic realm=TeleOko")
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
@@ -68,7 +86,7 @@ func BasicAuth(username, password string, enabled bool) gin.HandlerFunc {
 		// Сохраняем информацию о пользователе в контексте
 		c.Set("user", &User{
 			Username: username,
-			Password: password,
+			Role:     RoleAdmin,
 		})
 
 		// Если все проверки пройдены
@@ -107,3 +125,79 @@ func RequireAuth() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// SessionAuth - middleware, принимающее cookie сессии или Basic авторизацию.
+// Браузер без сессии перенаправляется на страницу входа, API получает 401.
+func SessionAuth(enabled bool) gin.HandlerFunc {
+	if !enabled {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	return func(c *gin.Context) {
+		// Сессия, созданная через форму входа
+		if token, err := c.Cookie(SessionCookieName); err == nil {
+			if user := lookupSession(token); user != nil {
+				// Роль берем из хранилища, чтобы изменения применялись сразу
+				if current := LookupUser(user.Username); current != nil {
+					c.Set("user", current)
+					c.Next()
+					return
+				}
+				DeleteSession(token)
+			}
+		}
+
+		// Basic авторизация для скриптов и внешних клиентов
+		if login, pass, ok := c.Request.BasicAuth(); ok {
+			if user, ok := Authenticate(login, pass); ok {
+				c.Set("user", user)
+				c.Next()
+				return
+			}
+			c.Header("WWW-Authenticate", "Basic realm=TeleOko")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Неверные учетные данные"})
+			return
+		}
+
+		// Страницы открываем через форму входа, без окна Basic авторизации
+		if c.Request.Method == http.MethodGet && strings.Contains(c.GetHeader("Accept"), "text/html") {
+			c.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+			c.Abort()
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+	}
+}
+
+// RequirePermission - middleware, пропускающее только роли с указанным правом.
+// При выключенной аутентификации доступ открыт.
+func RequirePermission(perm Permission, enabled bool) gin.HandlerFunc {
+	if !enabled {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	return func(c *gin.Context) {
+		user := GetCurrentUser(c)
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+			return
+		}
+		if !user.Role.HasPermission(perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// validCredentials сравнивает учетные данные за постоянное время
+func validCredentials(login, pass, username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(login), []byte(username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+	return userOK && passOK
+}
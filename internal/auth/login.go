@@ -0,0 +1,72 @@
+// internal/auth/login.go
+package auth
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginPage отображает форму входа
+func LoginPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"next": safeRedirect(c.Query("next")),
+	})
+}
+
+// Login проверяет форму входа и создает сессию
+func Login(c *gin.Context) {
+	login := c.PostForm("username")
+	pass := c.PostForm("password")
+	next := safeRedirect(c.PostForm("next"))
+
+	user, ok := Authenticate(login, pass)
+	if !ok {
+		log.Printf("🔒 Неудачная попытка входа: %s (%s)", login, c.ClientIP())
+		c.HTML(http.StatusUnauthorized, "login.html", gin.H{
+			"next":  next,
+			"error": "Неверное имя пользователя или пароль",
+		})
+		return
+	}
+
+	token, err := CreateSession(user)
+	if err != nil {
+		log.Printf("❌ Ошибка создания сессии: %v", err)
+		c.HTML(http.StatusInternalServerError, "login.html", gin.H{
+			"next":  next,
+			"error": "Ошибка создания сессии",
+		})
+		return
+	}
+
+	log.Printf("🔓 Вход пользователя %s [%s] (%s)", user.Username, user.Role, c.ClientIP())
+	setSessionCookie(c, token, int(sessionTTL.Seconds()))
+	c.Redirect(http.StatusFound, next)
+}
+
+// Logout завершает сессию и возвращает на страницу входа
+func Logout(c *gin.Context) {
+	if token, err := c.Cookie(SessionCookieName); err == nil {
+		DeleteSession(token)
+	}
+
+	setSessionCookie(c, "", -1)
+	c.Redirect(http.StatusFound, "/login")
+}
+
+// setSessionCookie устанавливает или удаляет cookie сессии
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookieName, token, maxAge, "/", "", c.Request.TLS != nil, true)
+}
+
+// safeRedirect допускает только локальные пути для перехода после входа
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
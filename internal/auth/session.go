@@ -0,0 +1,97 @@
+// internal/auth/session.go
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// SessionCookieName - имя cookie с идентификатором сессии
+	SessionCookieName = "teleoko_session"
+	// sessionTTL - время жизни сессии без активности
+	sessionTTL = 12 * time.Hour
+)
+
+// session - сессия пользователя, вошедшего через форму
+type session struct {
+	user      *User
+	expiresAt time.Time
+}
+
+var (
+	sessions   = make(map[string]*session)
+	sessionsMu sync.Mutex
+)
+
+// CreateSession создает сессию для пользователя и возвращает ее токен
+func CreateSession(user *User) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	cleanupSessionsLocked()
+	sessions[token] = &session{
+		user:      user,
+		expiresAt: time.Now().Add(sessionTTL),
+	}
+
+	return token, nil
+}
+
+// lookupSession возвращает пользователя сессии и продлевает ее
+func lookupSession(token string) *User {
+	if token == "" {
+		return nil
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	s, ok := sessions[token]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(s.expiresAt) {
+		delete(sessions, token)
+		return nil
+	}
+
+	s.expiresAt = time.Now().Add(sessionTTL)
+	return s.user
+}
+
+// DeleteSession завершает сессию
+func DeleteSession(token string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	delete(sessions, token)
+}
+
+// DeleteUserSessions завершает все сессии пользователя
+func DeleteUserSessions(username string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for token, s := range sessions {
+		if s.user.Username == username {
+			delete(sessions, token)
+		}
+	}
+}
+
+// cleanupSessionsLocked удаляет истекшие сессии (вызывается под sessionsMu)
+func cleanupSessionsLocked() {
+	now := time.Now()
+	for token, s := range sessions {
+		if now.After(s.expiresAt) {
+			delete(sessions, token)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// internal/auth/users.go
+package auth
+
+import (
+	"TeleOko/internal/config"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role - роль пользователя
+type Role string
+
+// Роли пользователей
+const (
+	RoleViewer        Role = "viewer"
+	RoleArchiveViewer Role = "archive-viewer"
+	RoleAdmin         Role = "admin"
+)
+
+// Permission - право на группу маршрутов
+type Permission string
+
+// Права доступа
+const (
+	PermLive    Permission = "live"    // прямой эфир и снимки
+	PermArchive Permission = "archive" // поиск и воспроизведение архива
+	PermAdmin   Permission = "admin"   // управление системой и пользователями
+)
+
+// rolePermissions - права каждой роли
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:        {PermLive},
+	RoleArchiveViewer: {PermLive, PermArchive},
+	RoleAdmin:         {PermLive, PermArchive, PermAdmin},
+}
+
+// Ошибки управления пользователями
+var (
+	ErrUserExists   = errors.New("пользователь уже существует")
+	ErrUserNotFound = errors.New("пользователь не найден")
+	ErrInvalidRole  = errors.New("неизвестная роль")
+	ErrLastAdmin    = errors.New("нельзя удалить последнего администратора")
+	ErrFirstAdmin   = errors.New("первый пользователь должен быть администратором")
+)
+
+// ValidRole проверяет, существует ли роль
+func ValidRole(role Role) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission проверяет, есть ли у роли указанное право
+func (r Role) HasPermission(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// HashPassword хеширует пароль bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("ошибка хеширования пароля: %v", err)
+	}
+	return string(hash), nil
+}
+
+// Authenticate проверяет имя и пароль пользователя.
+// Если список пользователей пуст, используется учетная запись
+// auth.username/auth.password из конфигурации с ролью администратора.
+func Authenticate(username, password string) (*User, bool) {
+	authConfig := config.GetAuth()
+	if len(authConfig.Users) == 0 {
+		if authConfig.Username != "" && validCredentials(username, password, authConfig.Username, authConfig.Password) {
+			return &User{Username: authConfig.Username, Role: RoleAdmin}, true
+		}
+		return nil, false
+	}
+
+	for _, u := range authConfig.Users {
+		if subtle.ConstantTimeCompare([]byte(u.Username), []byte(username)) != 1 {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+			return nil, false
+		}
+		return newUser(u, authConfig.RoleChannels), true
+	}
+
+	// Выполняем сравнение и для неизвестного пользователя, чтобы не выдавать
+	// его отсутствие временем ответа
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+	return nil, false
+}
+
+// dummyHash - хеш для сравнения при неизвестном пользователе
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("teleoko"), bcrypt.DefaultCost)
+
+// LookupUser возвращает актуальные данные пользователя
+func LookupUser(username string) *User {
+	authConfig := config.GetAuth()
+	if len(authConfig.Users) == 0 {
+		if username == authConfig.Username {
+			return &User{Username: username, Role: RoleAdmin}
+		}
+		return nil
+	}
+
+	for _, u := range authConfig.Users {
+		if u.Username == username {
+			return newUser(u, authConfig.RoleChannels)
+		}
+	}
+	return nil
+}
+
+// newUser формирует пользователя с итоговым списком разрешенных каналов:
+// собственный список пользователя, иначе список роли, иначе все каналы
+func newUser(u config.UserConfig, roleChannels map[string][]string) *User {
+	user := &User{Username: u.Username, Role: Role(u.Role)}
+	if user.Role == RoleAdmin {
+		return user
+	}
+
+	if len(u.Channels) > 0 {
+		user.Channels = append([]string{}, u.Channels...)
+	} else if channels, ok := roleChannels[u.Role]; ok {
+		user.Channels = append([]string{}, channels...)
+	}
+	return user
+}
+
+// ListUsers возвращает список пользователей без хешей паролей
+func ListUsers() []User {
+	authConfig := config.GetAuth()
+	users := make([]User, 0, len(authConfig.Users))
+	for _, u := range authConfig.Users {
+		users = append(users, *newUser(u, authConfig.RoleChannels))
+	}
+	return users
+}
+
+// AddUser создает пользователя и сохраняет конфигурацию
+func AddUser(username, password string, role Role) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("имя пользователя и пароль обязательны")
+	}
+	if !ValidRole(role) {
+		return fmt.Errorf("%w: %s", ErrInvalidRole, role)
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return config.UpdateUsers(func(users []config.UserConfig) ([]config.UserConfig, error) {
+		// Первый пользователь заменяет учетную запись auth.username,
+		// поэтому без администратора управлять системой будет некому
+		if len(users) == 0 && role != RoleAdmin {
+			return nil, ErrFirstAdmin
+		}
+
+		for _, u := range users {
+			if u.Username == username {
+				return nil, fmt.Errorf("%w: %s", ErrUserExists, username)
+			}
+		}
+
+		return append(users, config.UserConfig{
+			Username:     username,
+			PasswordHash: hash,
+			Role:         string(role),
+		}), nil
+	})
+}
+
+// RemoveUser удаляет пользователя и завершает его сессии
+func RemoveUser(username string) error {
+	err := config.UpdateUsers(func(users []config.UserConfig) ([]config.UserConfig, error) {
+		index := -1
+		admins := 0
+		for i, u := range users {
+			if u.Username == username {
+				index = i
+			}
+			if Role(u.Role) == RoleAdmin {
+				admins++
+			}
+		}
+
+		if index < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+		}
+		if Role(users[index].Role) == RoleAdmin && admins == 1 {
+			return nil, ErrLastAdmin
+		}
+		return append(users[:index], users[index+1:]...), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	DeleteUserSessions(username)
+	return nil
+}
+
+// ResetPassword задает новый пароль пользователя и завершает его сессии
+func ResetPassword(username, password string) error {
+	if password == "" {
+		return fmt.Errorf("пароль обязателен")
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = updateUser(username, func(u *config.UserConfig) {
+		u.PasswordHash = hash
+	})
+	if err != nil {
+		return err
+	}
+
+	DeleteUserSessions(username)
+	return nil
+}
+
+// SetUserChannels задает список разрешенных каналов пользователя.
+// Пустой список возвращает доступ по роли.
+func SetUserChannels(username string, channels []string) error {
+	return updateUser(username, func(u *config.UserConfig) {
+		u.Channels = channels
+	})
+}
+
+// updateUser изменяет учетную запись пользователя и сохраняет конфигурацию
+func updateUser(username string, fn func(u *config.UserConfig)) error {
+	return config.UpdateUsers(func(users []config.UserConfig) ([]config.UserConfig, error) {
+		for i := range users {
+			if users[i].Username == username {
+				fn(&users[i])
+				return users, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	})
+}
@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 )
 
 // Config содержит конфигурацию приложения
@@ -20,32 +24,156 @@ type Config struct {
 		IP       string `json:"ip"`
 		Username string `json:"username"`
 		Password string `json:"password"`
-		Port     int    `json:"port"`
+		Port     int    `json:"port"`      // RTSP порт
+		HTTPPort int    `json:"http_port"` // порт веб-интерфейса (ISAPI)
 	} `json:"hikvision"`
 
 	Go2RTC struct {
-		Port    int  `json:"port"`
-		Enabled bool `json:"enabled"`
+		Port         int  `json:"port"`
+		Enabled      bool `json:"enabled"`
+		StartTimeout int  `json:"start_timeout"` // секунды ожидания готовности API
 	} `json:"go2rtc"`
 
-	Auth struct {
-		Enabled  bool   `json:"enabled"`
-		Username string `json:"username"`
-		Password string `json:"password"`
-	} `json:"auth"`
+	Export struct {
+		MaxDuration int `json:"max_duration"` // максимальная длительность клипа, секунды
+	} `json:"export"`
+
+	Events struct {
+		Enabled       bool `json:"enabled"`        // подписка на события устройств (alertStream)
+		Snapshots     bool `json:"snapshots"`      // сохранять снимок в начале события
+		RetentionDays int  `json:"retention_days"` // сколько дней хранить журнал событий
+		MaxRecords    int  `json:"max_records"`    // максимум записей в журнале
+	} `json:"events"`
+
+	Data struct {
+		Dir string `json:"dir"` // каталог данных (журнал событий, снимки)
+	} `json:"data"`
+
+	Webhooks struct {
+		// PublicURL - адрес TeleOko для ссылок на снимки (по умолчанию IP сервера)
+		PublicURL string          `json:"public_url"`
+		Hooks     []WebhookConfig `json:"hooks,omitempty"`
+	} `json:"webhooks"`
+
+	// Devices - видеорегистраторы и камеры. Если список пуст,
+	// используется единственное устройство из секции hikvision.
+	Devices []Device `json:"devices,omitempty"`
+
+	Auth AuthConfig `json:"auth"`
 
 	Channels []Channel `json:"channels"`
 }
 
+// AuthConfig - настройки аутентификации и учетные записи
+type AuthConfig struct {
+	Enabled  bool         `json:"enabled"`
+	Username string       `json:"username"`
+	Password string       `json:"password"`
+	Users    []UserConfig `json:"users,omitempty"`
+
+	// RoleChannels - списки разрешенных каналов для ролей
+	RoleChannels map[string][]string `json:"role_channels,omitempty"`
+}
+
+// UserConfig - учетная запись пользователя с хешем пароля
+type UserConfig struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+
+	// Channels - разрешенные каналы пользователя (пусто - по роли)
+	Channels []string `json:"channels,omitempty"`
+}
+
+// WebhookConfig - адрес, на который отправляются события
+type WebhookConfig struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"` // ключ подписи HMAC-SHA256
+
+	// Channels и Events ограничивают отправляемые события (пусто - все)
+	Channels []string `json:"channels,omitempty"`
+	Events   []string `json:"events,omitempty"`
+
+	// Snapshot прикладывает ссылку на снимок канала
+	Snapshot bool `json:"snapshot"`
+	// MaxRetries - число повторов при ошибке доставки (по умолчанию 5)
+	MaxRetries int `json:"max_retries,omitempty"`
+}
+
+// Производители устройств
+const (
+	VendorHikvision = "hikvision"
+	VendorGeneric   = "generic" // любая RTSP камера, URL канала задается вручную
+)
+
+// DefaultDeviceID - ID устройства, созданного из секции hikvision
+const DefaultDeviceID = "default"
+
+// Порты устройств по умолчанию
+const (
+	DefaultRTSPPort  = 554
+	DefaultHTTPPort  = 80
+	DefaultHTTPSPort = 443
+)
+
+// Device представляет видеорегистратор или отдельную камеру
+type Device struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Vendor   string `json:"vendor"`
+	IP       string `json:"ip"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	RTSPPort  int `json:"rtsp_port"`
+	HTTPPort  int `json:"http_port"`
+	HTTPSPort int `json:"https_port"`
+
+	// UseHTTPS включает обращение к ISAPI по HTTPS
+	UseHTTPS bool `json:"use_https"`
+	// InsecureSkipVerify разрешает самоподписанные сертификаты регистратора
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+// ISAPIBaseURL возвращает адрес веб-сервера устройства для ISAPI
+func (d *Device) ISAPIBaseURL() string {
+	if d.UseHTTPS {
+		return fmt.Sprintf("https://%s", net.JoinHostPort(d.IP, strconv.Itoa(d.HTTPSPort)))
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(d.IP, strconv.Itoa(d.HTTPPort)))
+}
+
 // Channel представляет канал камеры
 type Channel struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 	URL  string `json:"url"`
+
+	// Device - ID устройства, которому принадлежит канал
+	Device string `json:"device,omitempty"`
+	// DeviceChannel - номер канала на устройстве, если отличается от ID
+	DeviceChannel string `json:"device_channel,omitempty"`
 }
 
-// Глобальная переменная для хранения конфигурации
-var GlobalConfig Config
+// DeviceChannelID возвращает номер канала на устройстве
+func (c *Channel) DeviceChannelID() string {
+	if c.DeviceChannel != "" {
+		return c.DeviceChannel
+	}
+	return c.ID
+}
+
+// Глобальная переменная для хранения конфигурации. Заменяется и изменяется
+// только под configMu, поэтому остальные пакеты читают ее через функции Get*.
+// Срезы каналов, устройств и пользователей после публикации не изменяются.
+var (
+	GlobalConfig Config
+	configMu     sync.RWMutex
+
+	// configPath - файл, из которого загружена конфигурация
+	configPath = "config.json"
+)
 
 // Значения по умолчанию
 var defaultConfig = Config{
@@ -59,24 +187,45 @@ var defaultConfig = Config{
 		Username string `json:"username"`
 		Password string `json:"password"`
 		Port     int    `json:"port"`
+		HTTPPort int    `json:"http_port"`
 	}{
 		IP:       "192.168.8.5",
 		Username: "admin",
 		Password: "oborotni2447",
 		Port:     554,
+		HTTPPort: 80,
 	},
 	Go2RTC: struct {
-		Port    int  `json:"port"`
-		Enabled bool `json:"enabled"`
+		Port         int  `json:"port"`
+		Enabled      bool `json:"enabled"`
+		StartTimeout int  `json:"start_timeout"`
 	}{
-		Port:    1984,
-		Enabled: true,
+		Port:         1984,
+		Enabled:      true,
+		StartTimeout: 15,
 	},
-	Auth: struct {
-		Enabled  bool   `json:"enabled"`
-		Username string `json:"username"`
-		Password string `json:"password"`
+	Export: struct {
+		MaxDuration int `json:"max_duration"`
+	}{
+		MaxDuration: 3600,
+	},
+	Events: struct {
+		Enabled       bool `json:"enabled"`
+		Snapshots     bool `json:"snapshots"`
+		RetentionDays int  `json:"retention_days"`
+		MaxRecords    int  `json:"max_records"`
+	}{
+		Enabled:       true,
+		Snapshots:     true,
+		RetentionDays: 30,
+		MaxRecords:    100000,
+	},
+	Data: struct {
+		Dir string `json:"dir"`
 	}{
+		Dir: "data",
+	},
+	Auth: AuthConfig{
 		Enabled:  false,
 		Username: "admin",
 		Password: "password",
@@ -94,7 +243,9 @@ var defaultConfig = Config{
 	},
 }
 
-// Load загружает конфигурацию из файла или использует значения по умолчанию
+// Load загружает конфигурацию из файла или использует значения по умолчанию.
+// Новая конфигурация собирается отдельно и подменяет текущую целиком, поэтому
+// Load можно вызывать повторно во время работы сервера.
 func Load() (*Config, error) {
 	// Пути к файлу конфигурации
 	configPaths := []string{
@@ -110,83 +261,230 @@ func Load() (*Config, error) {
 		}
 	}
 
-	// Если файл конфигурации найден, загружаем его
-	if configFile != "" {
-		log.Printf("Загрузка конфигурации из файла: %s", configFile)
-		data, err := ioutil.ReadFile(configFile)
-		if err != nil {
-			log.Printf("Ошибка чтения файла конфигурации: %v", err)
-			GlobalConfig = defaultConfig
-			generateChannelURLs()
-			return &GlobalConfig, nil
-		}
+	// Если файл не найден, создаем его с настройками по умолчанию.
+	// Записываются исходные значения: устройство из секции hikvision
+	// добавляется при каждой загрузке и в файл не попадает.
+	if configFile == "" {
+		log.Println("Файл конфигурации не найден, создание файла с настройками по умолчанию")
 
-		var config Config
-		if err := json.Unmarshal(data, &config); err != nil {
-			log.Printf("Ошибка разбора файла конфигурации: %v", err)
-			GlobalConfig = defaultConfig
-			generateChannelURLs()
-			return &GlobalConfig, nil
+		configFile = "config.json"
+		if err := writeFile(configFile, defaultConfig); err != nil {
+			log.Printf("Ошибка сохранения конфигурации: %v", err)
 		}
+		return prepare(defaultConfig, configFile), nil
+	}
+
+	log.Printf("Загрузка конфигурации из файла: %s", configFile)
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		log.Printf("Ошибка чтения файла конфигурации: %v", err)
+		return prepare(defaultConfig, configFile), nil
+	}
 
-		GlobalConfig = config
-		generateChannelURLs()
-		return &config, nil
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.Printf("Ошибка разбора файла конфигурации: %v", err)
+		return prepare(defaultConfig, configFile), nil
 	}
 
-	// Если файл не найден, создаем его с настройками по умолчанию
-	log.Println("Файл конфигурации не найден, создание файла с настройками по умолчанию")
+	return prepare(config, configFile), nil
+}
 
-	GlobalConfig = defaultConfig
-	generateChannelURLs()
+// prepare дополняет конфигурацию и делает ее текущей. Возвращает копию,
+// которую вызывающий может читать без блокировки.
+func prepare(cfg Config, path string) *Config {
+	normalizeDevices(&cfg)
+	generateChannelURLs(&cfg)
+
+	configMu.Lock()
+	GlobalConfig = cfg
+	configPath = path
+	configMu.Unlock()
+	return &cfg
+}
 
-	// Сохраняем конфигурацию
-	if err := Save(); err != nil {
-		log.Printf("Ошибка сохранения конфигурации: %v", err)
+// normalizeDevices создает устройство из секции hikvision, если список
+// устройств пуст, и привязывает каналы без устройства к первому устройству
+func normalizeDevices(cfg *Config) {
+	// Срезы копируются, чтобы не изменять значения по умолчанию
+	cfg.Devices = append([]Device(nil), cfg.Devices...)
+	cfg.Channels = append([]Channel(nil), cfg.Channels...)
+
+	if len(cfg.Devices) == 0 {
+		cfg.Devices = []Device{{
+			ID:       DefaultDeviceID,
+			Name:     "Hikvision",
+			Vendor:   VendorHikvision,
+			IP:       cfg.Hikvision.IP,
+			RTSPPort: cfg.Hikvision.Port,
+			HTTPPort: cfg.Hikvision.HTTPPort,
+			Username: cfg.Hikvision.Username,
+			Password: cfg.Hikvision.Password,
+		}}
+	}
+
+	for i := range cfg.Devices {
+		device := &cfg.Devices[i]
+		if device.Vendor == "" {
+			device.Vendor = VendorHikvision
+		}
+		if device.RTSPPort == 0 {
+			device.RTSPPort = DefaultRTSPPort
+		}
+		if device.HTTPPort == 0 {
+			device.HTTPPort = DefaultHTTPPort
+		}
+		if device.HTTPSPort == 0 {
+			device.HTTPSPort = DefaultHTTPSPort
+		}
 	}
 
-	return &GlobalConfig, nil
+	for i := range cfg.Channels {
+		if cfg.Channels[i].Device == "" {
+			cfg.Channels[i].Device = cfg.Devices[0].ID
+		}
+	}
 }
 
-// generateChannelURLs генерирует RTSP URL для каналов
-func generateChannelURLs() {
-	baseURL := fmt.Sprintf("rtsp://%s:%s@%s:%d/Streaming/Channels/",
-		GlobalConfig.Hikvision.Username,
-		GlobalConfig.Hikvision.Password,
-		GlobalConfig.Hikvision.IP,
-		GlobalConfig.Hikvision.Port)
+// generateChannelURLs генерирует RTSP URL для каналов Hikvision
+func generateChannelURLs(cfg *Config) {
+	for i := range cfg.Channels {
+		channel := &cfg.Channels[i]
+		if channel.URL != "" {
+			continue
+		}
 
-	for i := range GlobalConfig.Channels {
-		if GlobalConfig.Channels[i].URL == "" {
-			GlobalConfig.Channels[i].URL = baseURL + GlobalConfig.Channels[i].ID
+		device := cfg.device(channel.Device)
+		if device == nil {
+			log.Printf("⚠️ Канал %s ссылается на неизвестное устройство %q", channel.ID, channel.Device)
+			continue
+		}
+		if device.Vendor != VendorHikvision {
+			log.Printf("⚠️ Для канала %s устройства %s нужно указать url", channel.ID, device.ID)
+			continue
+		}
+
+		channel.URL = fmt.Sprintf("rtsp://%s:%s@%s:%d/Streaming/Channels/%s",
+			device.Username, device.Password, device.IP, device.RTSPPort, channel.DeviceChannelID())
+	}
+}
+
+// device возвращает устройство конфигурации по ID
+func (c *Config) device(id string) *Device {
+	for i := range c.Devices {
+		if c.Devices[i].ID == id {
+			return &c.Devices[i]
 		}
 	}
+	return nil
 }
 
-// Save сохраняет текущую конфигурацию в файл
-func Save() error {
-	// Создаем директорию config, если ее нет
-	if err := os.MkdirAll("config", 0755); err != nil {
+// writeFile записывает значение в JSON файл. Запись идет во временный
+// файл с последующим переименованием, чтобы не оставить файл обрезанным.
+func writeFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
 		return err
 	}
 
-	// Сериализуем конфигурацию в JSON
-	data, err := json.MarshalIndent(GlobalConfig, "", "    ")
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.json")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	// Записываем в файл
-	return ioutil.WriteFile("config.json", data, 0644)
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// GetAuth возвращает настройки аутентификации. Срезы и карты результата
+// общие с текущей конфигурацией и не должны изменяться.
+func GetAuth() AuthConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return GlobalConfig.Auth
+}
+
+// UpdateUsers изменяет список пользователей и сохраняет его в файл
+// конфигурации. fn получает список и возвращает новый; при ошибке fn или
+// записи файла ничего не меняется. Изменения выполняются по очереди.
+//
+// Список берется из файла, а не из памяти: его мог изменить cmd/users при
+// работающем сервере. Остальные секции файла записываются без изменений,
+// дополненные при загрузке значения (устройство по умолчанию, URL каналов)
+// в файл не попадают.
+func UpdateUsers(fn func(users []UserConfig) ([]UserConfig, error)) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	sections := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ошибка чтения %s: %v", configPath, err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &sections); err != nil {
+			return fmt.Errorf("ошибка разбора %s: %v", configPath, err)
+		}
+	}
+
+	authSection := make(map[string]json.RawMessage)
+	if raw, ok := sections["auth"]; ok {
+		if err := json.Unmarshal(raw, &authSection); err != nil {
+			return fmt.Errorf("ошибка разбора секции auth: %v", err)
+		}
+	}
+
+	var users []UserConfig
+	if raw, ok := authSection["users"]; ok {
+		if err := json.Unmarshal(raw, &users); err != nil {
+			return fmt.Errorf("ошибка разбора auth.users: %v", err)
+		}
+	}
+
+	users, err = fn(users)
+	if err != nil {
+		return err
+	}
+
+	delete(authSection, "users")
+	if len(users) > 0 {
+		if authSection["users"], err = json.Marshal(users); err != nil {
+			return err
+		}
+	}
+	if sections["auth"], err = json.Marshal(authSection); err != nil {
+		return err
+	}
+	if err := writeFile(configPath, sections); err != nil {
+		return fmt.Errorf("ошибка записи %s: %v", configPath, err)
+	}
+
+	GlobalConfig.Auth.Users = users
+	return nil
 }
 
 // GetChannels возвращает список каналов
 func GetChannels() []Channel {
+	configMu.RLock()
+	defer configMu.RUnlock()
 	return GlobalConfig.Channels
 }
 
 // GetChannelByID возвращает канал по ID
 func GetChannelByID(id string) *Channel {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
 	for i := range GlobalConfig.Channels {
 		if GlobalConfig.Channels[i].ID == id {
 			return &GlobalConfig.Channels[i]
@@ -195,20 +493,138 @@ func GetChannelByID(id string) *Channel {
 	return nil
 }
 
-// GetHikvisionCredentials возвращает учетные данные для Hikvision
-func GetHikvisionCredentials() (string, string, string, int) {
-	return GlobalConfig.Hikvision.IP,
-		GlobalConfig.Hikvision.Username,
-		GlobalConfig.Hikvision.Password,
-		GlobalConfig.Hikvision.Port
+// GetDevices возвращает список устройств
+func GetDevices() []Device {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return GlobalConfig.Devices
+}
+
+// GetDevice возвращает устройство по ID
+func GetDevice(id string) *Device {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return GlobalConfig.device(id)
+}
+
+// ResolveChannel возвращает канал и устройство, к которому он относится
+func ResolveChannel(channelID string) (*Channel, *Device, error) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	var channel *Channel
+	for i := range GlobalConfig.Channels {
+		if GlobalConfig.Channels[i].ID == channelID {
+			channel = &GlobalConfig.Channels[i]
+			break
+		}
+	}
+	if channel == nil {
+		return nil, nil, fmt.Errorf("канал %s не найден", channelID)
+	}
+
+	device := GlobalConfig.device(channel.Device)
+	if device == nil {
+		return nil, nil, fmt.Errorf("устройство %q канала %s не найдено", channel.Device, channelID)
+	}
+
+	return channel, device, nil
 }
 
 // GetGo2RTCPort возвращает порт go2rtc
 func GetGo2RTCPort() int {
+	configMu.RLock()
+	defer configMu.RUnlock()
 	return GlobalConfig.Go2RTC.Port
 }
 
+// GetGo2RTCStartTimeout возвращает максимальное время ожидания готовности go2rtc
+func GetGo2RTCStartTimeout() time.Duration {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	if GlobalConfig.Go2RTC.StartTimeout <= 0 {
+		return 15 * time.Second
+	}
+	return time.Duration(GlobalConfig.Go2RTC.StartTimeout) * time.Second
+}
+
+// GetExportMaxDuration возвращает максимальную длительность выгружаемого клипа
+func GetExportMaxDuration() time.Duration {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	if GlobalConfig.Export.MaxDuration <= 0 {
+		return time.Hour
+	}
+	return time.Duration(GlobalConfig.Export.MaxDuration) * time.Second
+}
+
+// IsEventsEnabled проверяет, включена ли подписка на события устройств
+func IsEventsEnabled() bool {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return GlobalConfig.Events.Enabled
+}
+
+// IsEventSnapshotsEnabled проверяет, сохранять ли снимки событий
+func IsEventSnapshotsEnabled() bool {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return GlobalConfig.Events.Snapshots
+}
+
+// GetDataDir возвращает каталог данных приложения
+func GetDataDir() string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	if GlobalConfig.Data.Dir == "" {
+		return "data"
+	}
+	return GlobalConfig.Data.Dir
+}
+
+// GetEventsRetention возвращает срок хранения журнала событий
+func GetEventsRetention() time.Duration {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	if GlobalConfig.Events.RetentionDays <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(GlobalConfig.Events.RetentionDays) * 24 * time.Hour
+}
+
+// GetEventsMaxRecords возвращает максимальное число записей журнала событий
+func GetEventsMaxRecords() int {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	if GlobalConfig.Events.MaxRecords <= 0 {
+		return 100000
+	}
+	return GlobalConfig.Events.MaxRecords
+}
+
+// GetWebhooks возвращает настроенные вебхуки
+func GetWebhooks() []WebhookConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return GlobalConfig.Webhooks.Hooks
+}
+
+// GetWebhookPublicURL возвращает внешний адрес TeleOko для ссылок в вебхуках
+func GetWebhookPublicURL() string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return GlobalConfig.Webhooks.PublicURL
+}
+
 // IsGo2RTCEnabled проверяет, включен ли go2rtc
 func IsGo2RTCEnabled() bool {
+	configMu.RLock()
+	defer configMu.RUnlock()
 	return GlobalConfig.Go2RTC.Enabled
 }
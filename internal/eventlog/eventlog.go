@@ -0,0 +1,223 @@
+// internal/eventlog/eventlog.go
+package eventlog
+
+import (
+	"TeleOko/internal/config"
+	"TeleOko/internal/events"
+	"TeleOko/internal/hikvision"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// mergeWindow - повторные active сообщения в этом окне продолжают событие
+	mergeWindow = 10 * time.Second
+	// sweepInterval - период завершения событий без новых сообщений
+	sweepInterval = 30 * time.Second
+	// retentionInterval - период очистки журнала
+	retentionInterval = time.Hour
+	// snapshotTimeout - ограничение времени получения снимка
+	snapshotTimeout = 15 * time.Second
+	// queueSize - очередь событий журнала при всплесках
+	queueSize = 1024
+)
+
+// statefulTypes - события, которые завершаются только сообщением inactive
+// (недоступность устройства, падение go2rtc), а не по паузе в сообщениях
+var statefulTypes = map[string]bool{
+	hikvision.EventDeviceOffline: true,
+	events.TypeGo2RTCDown:        true,
+}
+
+// openRecord - незавершенное событие
+type openRecord struct {
+	id       string
+	lastSeen time.Time // когда пришло последнее сообщение (время сервера)
+	stateful bool
+}
+
+// Start открывает журнал в каталоге данных и записывает в него события
+// устройств до отмены ctx. Вызывается до events.Start, чтобы не потерять
+// первые события.
+func Start(ctx context.Context) error {
+	dir := config.GetDataDir()
+	if err := os.MkdirAll(snapshotsDir(), 0755); err != nil {
+		return fmt.Errorf("ошибка создания каталога данных %s: %v", dir, err)
+	}
+
+	if err := openDB(filepath.Join(dir, "events.db")); err != nil {
+		return err
+	}
+	if err := closeActive(); err != nil {
+		log.Printf("⚠️ Ошибка завершения активных событий журнала: %v", err)
+	}
+
+	ch, unsubscribe := events.SubscribeBuffered(queueSize)
+	go run(ctx, ch, unsubscribe)
+
+	log.Printf("🗂️ Журнал событий: %s", filepath.Join(dir, "events.db"))
+	return nil
+}
+
+// run записывает события и периодически очищает журнал
+func run(ctx context.Context, ch <-chan hikvision.Event, unsubscribe func()) {
+	defer closeDB()
+	defer unsubscribe()
+
+	open := make(map[string]*openRecord)
+	sweep := time.NewTicker(sweepInterval)
+	defer sweep.Stop()
+	retention := time.NewTicker(retentionInterval)
+	defer retention.Stop()
+
+	applyRetention()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			record(ctx, open, event)
+		case <-sweep.C:
+			closeStale(open, time.Now())
+		case <-retention.C:
+			applyRetention()
+		}
+	}
+}
+
+// record добавляет событие в журнал или продолжает незавершенное
+func record(ctx context.Context, open map[string]*openRecord, event hikvision.Event) {
+	now := time.Now()
+	key := event.Device + "/" + event.DeviceChannel + "/" + event.RawType
+	current := open[key]
+	if current != nil && !statefulTypes[event.Type] && now.Sub(current.lastSeen) > mergeWindow {
+		finish(current.id, nil)
+		delete(open, key)
+		current = nil
+	}
+
+	if event.State == hikvision.EventStateInactive {
+		if current != nil {
+			finish(current.id, &event.Time)
+			delete(open, key)
+		}
+		return
+	}
+
+	if current != nil {
+		current.lastSeen = now
+		err := update(current.id, func(r *Record) {
+			if event.Time.After(r.End) {
+				r.End = event.Time
+			}
+		})
+		if err != nil {
+			log.Printf("⚠️ Ошибка обновления события %s в журнале: %v", current.id, err)
+		}
+		return
+	}
+
+	r := Record{
+		ID:            event.ID,
+		Device:        event.Device,
+		Channel:       event.Channel,
+		DeviceChannel: event.DeviceChannel,
+		Type:          event.Type,
+		RawType:       event.RawType,
+		Description:   event.Description,
+		Start:         event.Time,
+		End:           event.Time,
+		Active:        true,
+	}
+	if err := insert(r); err != nil {
+		log.Printf("⚠️ Ошибка записи события %s в журнал: %v", r.ID, err)
+		return
+	}
+	open[key] = &openRecord{id: r.ID, lastSeen: now, stateful: statefulTypes[r.Type]}
+
+	if r.Channel != "" && config.IsEventSnapshotsEnabled() {
+		go captureSnapshot(ctx, r)
+	}
+}
+
+// finish завершает событие; end - время сообщения inactive, если оно пришло
+func finish(id string, end *time.Time) {
+	err := update(id, func(r *Record) {
+		r.Active = false
+		if end != nil && end.After(r.End) {
+			r.End = *end
+		}
+	})
+	if err != nil {
+		log.Printf("⚠️ Ошибка завершения события %s в журнале: %v", id, err)
+	}
+}
+
+// closeStale завершает события, по которым давно нет сообщений
+func closeStale(open map[string]*openRecord, now time.Time) {
+	for key, current := range open {
+		if !current.stateful && now.Sub(current.lastSeen) > mergeWindow {
+			finish(current.id, nil)
+			delete(open, key)
+		}
+	}
+}
+
+// captureSnapshot сохраняет снимок канала в начале события
+func captureSnapshot(ctx context.Context, r Record) {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	data, err := hikvision.GetSnapshot(ctx, r.Channel)
+	if err != nil {
+		log.Printf("⚠️ Ошибка снимка для события %s: %v", r.ID, err)
+		return
+	}
+
+	name := r.ID + ".jpg"
+	if err := os.WriteFile(filepath.Join(snapshotsDir(), name), data, 0644); err != nil {
+		log.Printf("⚠️ Ошибка сохранения снимка события %s: %v", r.ID, err)
+		return
+	}
+
+	if err := update(r.ID, func(r *Record) { r.Snapshot = name }); err != nil {
+		os.Remove(filepath.Join(snapshotsDir(), name))
+	}
+}
+
+// applyRetention удаляет записи старше срока хранения и сверх лимита
+func applyRetention() {
+	cutoff := time.Now().Add(-config.GetEventsRetention())
+	removed, snapshots, err := prune(cutoff, config.GetEventsMaxRecords())
+	if err != nil {
+		log.Printf("⚠️ Ошибка очистки журнала событий: %v", err)
+		return
+	}
+
+	for _, name := range snapshots {
+		os.Remove(filepath.Join(snapshotsDir(), name))
+	}
+	if removed > 0 {
+		log.Printf("🧹 Журнал событий: удалено записей: %d", removed)
+	}
+}
+
+// SnapshotPath возвращает путь к снимку записи или пустую строку
+func SnapshotPath(r Record) string {
+	if r.Snapshot == "" {
+		return ""
+	}
+	return filepath.Join(snapshotsDir(), filepath.Base(r.Snapshot))
+}
+
+// snapshotsDir возвращает каталог снимков событий
+func snapshotsDir() string {
+	return filepath.Join(config.GetDataDir(), "snapshots")
+}
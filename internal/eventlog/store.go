@@ -0,0 +1,326 @@
+// internal/eventlog/store.go
+package eventlog
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Ошибки журнала событий
+var (
+	ErrUnavailable   = errors.New("журнал событий недоступен")
+	ErrNotFound      = errors.New("событие не найдено")
+	ErrInvalidCursor = errors.New("неверный курсор")
+)
+
+// Бакеты базы: записи упорядочены по времени начала, индекс - по ID
+var (
+	recordsBucket = []byte("events")
+	indexBucket   = []byte("event_ids")
+)
+
+// Record - событие в журнале. Повторные сообщения active одного события
+// объединяются в одну запись с началом и окончанием.
+type Record struct {
+	ID            string    `json:"id"`
+	Device        string    `json:"device"`
+	Channel       string    `json:"channel,omitempty"`
+	DeviceChannel string    `json:"device_channel,omitempty"`
+	Type          string    `json:"type"`
+	RawType       string    `json:"raw_type"`
+	Description   string    `json:"description,omitempty"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Active        bool      `json:"active"`
+	Snapshot      string    `json:"snapshot,omitempty"` // имя файла снимка в каталоге snapshots
+}
+
+// Filter - условия выборки из журнала. Пустые поля не ограничивают выборку.
+// Start и End ограничивают время начала события.
+type Filter struct {
+	Channels map[string]bool
+	Types    map[string]bool
+	Start    time.Time
+	End      time.Time
+
+	// Allow дополнительно проверяет доступ к записи
+	Allow func(Record) bool
+}
+
+var (
+	db   *bolt.DB
+	dbMu sync.RWMutex
+)
+
+// openDB открывает базу журнала и создает бакеты
+func openDB(path string) error {
+	handle, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("ошибка открытия журнала событий %s: %v", path, err)
+	}
+
+	err = handle.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(recordsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(indexBucket)
+		return err
+	})
+	if err != nil {
+		handle.Close()
+		return fmt.Errorf("ошибка подготовки журнала событий: %v", err)
+	}
+
+	dbMu.Lock()
+	db = handle
+	dbMu.Unlock()
+	return nil
+}
+
+// closeDB закрывает базу журнала
+func closeDB() {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+}
+
+// withDB выполняет fn с открытой базой
+func withDB(fn func(*bolt.DB) error) error {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+
+	if db == nil {
+		return ErrUnavailable
+	}
+	return fn(db)
+}
+
+// insert добавляет запись в журнал
+func insert(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return withDB(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			key := recordKey(record.Start, record.ID)
+			if err := tx.Bucket(recordsBucket).Put(key, data); err != nil {
+				return err
+			}
+			return tx.Bucket(indexBucket).Put([]byte(record.ID), key)
+		})
+	})
+}
+
+// update изменяет запись журнала. Изменение выполняется в одной транзакции,
+// поэтому параллельные обновления разных полей не теряются.
+func update(id string, fn func(*Record)) error {
+	return withDB(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			key := tx.Bucket(indexBucket).Get([]byte(id))
+			if key == nil {
+				return fmt.Errorf("%w: %s", ErrNotFound, id)
+			}
+
+			records := tx.Bucket(recordsBucket)
+			var record Record
+			if err := json.Unmarshal(records.Get(key), &record); err != nil {
+				return err
+			}
+
+			fn(&record)
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			return records.Put(key, data)
+		})
+	})
+}
+
+// Get возвращает запись журнала по ID
+func Get(id string) (Record, error) {
+	var record Record
+	err := withDB(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			key := tx.Bucket(indexBucket).Get([]byte(id))
+			if key == nil {
+				return fmt.Errorf("%w: %s", ErrNotFound, id)
+			}
+			return json.Unmarshal(tx.Bucket(recordsBucket).Get(key), &record)
+		})
+	})
+	return record, err
+}
+
+// Query возвращает до limit записей, подходящих под фильтр, от новых к старым.
+// Если записей больше, возвращается курсор следующей страницы.
+func Query(filter Filter, cursor string, limit int) ([]Record, string, error) {
+	var from []byte
+	if cursor != "" {
+		key, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(key) <= 8 {
+			return nil, "", ErrInvalidCursor
+		}
+		from = key
+	} else if !filter.End.IsZero() {
+		// Первый ключ после окончания интервала
+		from = recordKey(filter.End.Add(time.Nanosecond), "")
+	}
+
+	records := make([]Record, 0, limit)
+	var next string
+	err := withDB(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(recordsBucket).Cursor()
+
+			var k, v []byte
+			if from == nil {
+				k, v = c.Last()
+			} else if k, _ = c.Seek(from); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+
+			for ; k != nil; k, v = c.Prev() {
+				var record Record
+				if err := json.Unmarshal(v, &record); err != nil {
+					continue
+				}
+				if !filter.Start.IsZero() && record.Start.Before(filter.Start) {
+					break
+				}
+				if !filter.match(record) {
+					continue
+				}
+
+				if len(records) == limit {
+					next = base64.RawURLEncoding.EncodeToString(recordKey(records[limit-1].Start, records[limit-1].ID))
+					break
+				}
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return records, next, nil
+}
+
+// match проверяет запись по фильтру
+func (f *Filter) match(record Record) bool {
+	if len(f.Channels) > 0 && !f.Channels[record.Channel] {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[record.Type] {
+		return false
+	}
+	if !f.End.IsZero() && record.Start.After(f.End) {
+		return false
+	}
+	return f.Allow == nil || f.Allow(record)
+}
+
+// prune удаляет записи старше cutoff и самые старые записи сверх maxRecords.
+// Возвращает имена файлов снимков удаленных записей.
+func prune(cutoff time.Time, maxRecords int) (int, []string, error) {
+	removed := 0
+	var snapshots []string
+
+	err := withDB(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			records := tx.Bucket(recordsBucket)
+			index := tx.Bucket(indexBucket)
+			overflow := records.Stats().KeyN - maxRecords
+
+			// Ключи собираются заранее: удаление под курсором сдвигает его
+			var keys [][]byte
+			c := records.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if len(keys) >= overflow && !keyTime(k).Before(cutoff) {
+					break
+				}
+				keys = append(keys, append([]byte(nil), k...))
+			}
+
+			for _, key := range keys {
+				var record Record
+				if err := json.Unmarshal(records.Get(key), &record); err == nil {
+					index.Delete([]byte(record.ID))
+					if record.Snapshot != "" {
+						snapshots = append(snapshots, record.Snapshot)
+					}
+				}
+				if err := records.Delete(key); err != nil {
+					return err
+				}
+				removed++
+			}
+			return nil
+		})
+	})
+	return removed, snapshots, err
+}
+
+// closeActive завершает записи, оставшиеся активными после перезапуска
+func closeActive() error {
+	return withDB(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			records := tx.Bucket(recordsBucket)
+
+			var keys [][]byte
+			var values [][]byte
+			err := records.ForEach(func(k, v []byte) error {
+				var record Record
+				if json.Unmarshal(v, &record) != nil || !record.Active {
+					return nil
+				}
+				record.Active = false
+				data, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, data)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for i, key := range keys {
+				if err := records.Put(key, values[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// recordKey - ключ записи: время начала (big-endian, наносекунды) и ID
+func recordKey(start time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(start.UnixNano()))
+	return append(key, id...)
+}
+
+// keyTime возвращает время начала из ключа записи
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
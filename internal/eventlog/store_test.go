@@ -0,0 +1,285 @@
+// internal/eventlog/store_test.go
+package eventlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// baseTime - время первой записи в тестах
+var baseTime = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// openTestDB открывает журнал во временном каталоге
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if err := openDB(filepath.Join(t.TempDir(), "events.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeDB)
+}
+
+// insertRecords добавляет n записей с интервалом в минуту. Каналы
+// чередуются: 101, 102, 101...
+func insertRecords(t *testing.T, n int) []Record {
+	t.Helper()
+
+	records := make([]Record, 0, n)
+	for i := 0; i < n; i++ {
+		record := Record{
+			ID:      fmt.Sprintf("event-%d", i),
+			Device:  "nvr",
+			Channel: []string{"101", "102"}[i%2],
+			Type:    "motion",
+			Start:   baseTime.Add(time.Duration(i) * time.Minute),
+			End:     baseTime.Add(time.Duration(i)*time.Minute + 10*time.Second),
+		}
+		if err := insert(record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// queryAll читает все страницы журнала и возвращает ID записей по страницам
+func queryAll(t *testing.T, filter Filter, limit int) [][]string {
+	t.Helper()
+
+	var pages [][]string
+	cursor := ""
+	for {
+		records, next, err := Query(filter, cursor, limit)
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		ids := make([]string, 0, len(records))
+		for _, r := range records {
+			ids = append(ids, r.ID)
+		}
+		pages = append(pages, ids)
+		if next == "" {
+			return pages
+		}
+		if len(pages) > 100 {
+			t.Fatal("курсор не продвигается")
+		}
+		cursor = next
+	}
+}
+
+func TestRecordKeyOrder(t *testing.T) {
+	// Ключи упорядочены по времени начала, при равном времени - по ID
+	keys := [][]byte{
+		recordKey(baseTime, "b"),
+		recordKey(baseTime.Add(time.Nanosecond), "a"),
+		recordKey(baseTime.Add(time.Hour), ""),
+	}
+	for i := 1; i < len(keys); i++ {
+		if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			t.Fatalf("ключ %d не меньше ключа %d", i-1, i)
+		}
+	}
+	if bytes.Compare(recordKey(baseTime, "a"), recordKey(baseTime, "b")) >= 0 {
+		t.Fatal("при равном времени ключи не упорядочены по ID")
+	}
+
+	if got := keyTime(recordKey(baseTime, "id")); !got.Equal(baseTime) {
+		t.Fatalf("keyTime = %s, ожидалось %s", got, baseTime)
+	}
+}
+
+func TestQueryPages(t *testing.T) {
+	openTestDB(t)
+	insertRecords(t, 5)
+
+	pages := queryAll(t, Filter{}, 2)
+	want := [][]string{
+		{"event-4", "event-3"},
+		{"event-2", "event-1"},
+		{"event-0"},
+	}
+	if fmt.Sprint(pages) != fmt.Sprint(want) {
+		t.Fatalf("страницы %v, ожидались %v", pages, want)
+	}
+
+	// Последняя полная страница не возвращает курсор
+	pages = queryAll(t, Filter{}, 5)
+	if len(pages) != 1 || len(pages[0]) != 5 {
+		t.Fatalf("страницы %v, ожидалась одна страница из 5 записей", pages)
+	}
+}
+
+func TestQuerySameStartTime(t *testing.T) {
+	openTestDB(t)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := insert(Record{ID: id, Channel: "101", Start: baseTime, End: baseTime}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Курсор содержит ID, поэтому записи с одним временем не теряются
+	pages := queryAll(t, Filter{}, 1)
+	want := [][]string{{"c"}, {"b"}, {"a"}}
+	if fmt.Sprint(pages) != fmt.Sprint(want) {
+		t.Fatalf("страницы %v, ожидались %v", pages, want)
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	openTestDB(t)
+	insertRecords(t, 6)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   [][]string
+	}{
+		{
+			name:   "канал",
+			filter: Filter{Channels: map[string]bool{"101": true}},
+			want:   [][]string{{"event-4", "event-2"}, {"event-0"}},
+		},
+		{
+			name:   "интервал",
+			filter: Filter{Start: baseTime.Add(time.Minute), End: baseTime.Add(3 * time.Minute)},
+			want:   [][]string{{"event-3", "event-2"}, {"event-1"}},
+		},
+		{
+			name:   "канал и интервал",
+			filter: Filter{Channels: map[string]bool{"102": true}, End: baseTime.Add(4 * time.Minute)},
+			want:   [][]string{{"event-3", "event-1"}},
+		},
+		{
+			name:   "доступ",
+			filter: Filter{Allow: func(r Record) bool { return r.ID != "event-5" }},
+			want:   [][]string{{"event-4", "event-3"}, {"event-2", "event-1"}, {"event-0"}},
+		},
+		{
+			name:   "тип",
+			filter: Filter{Types: map[string]bool{"linedetection": true}},
+			want:   [][]string{{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if pages := queryAll(t, tt.filter, 2); fmt.Sprint(pages) != fmt.Sprint(tt.want) {
+				t.Fatalf("страницы %v, ожидались %v", pages, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryInvalidCursor(t *testing.T) {
+	openTestDB(t)
+
+	for _, cursor := range []string{"не base64", "AAAA"} {
+		if _, _, err := Query(Filter{}, cursor, 10); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("курсор %q: ожидалась ErrInvalidCursor, получено %v", cursor, err)
+		}
+	}
+}
+
+func TestQueryUnavailable(t *testing.T) {
+	if _, _, err := Query(Filter{}, "", 10); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("ожидалась ErrUnavailable, получено %v", err)
+	}
+}
+
+func TestUpdateUsesIndex(t *testing.T) {
+	openTestDB(t)
+	insertRecords(t, 3)
+
+	err := update("event-1", func(r *Record) {
+		r.Active = true
+		r.Snapshot = "event-1.jpg"
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	record, err := Get("event-1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !record.Active || record.Snapshot != "event-1.jpg" || !record.Start.Equal(baseTime.Add(time.Minute)) {
+		t.Fatalf("запись после обновления %+v", record)
+	}
+
+	if err := update("unknown", func(*Record) {}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ожидалась ErrNotFound, получено %v", err)
+	}
+	if _, err := Get("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ожидалась ErrNotFound, получено %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	openTestDB(t)
+	insertRecords(t, 6)
+	for _, id := range []string{"event-0", "event-3"} {
+		if err := update(id, func(r *Record) { r.Snapshot = r.ID + ".jpg" }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// По возрасту: удаляются записи раньше cutoff
+	removed, snapshots, err := prune(baseTime.Add(2*time.Minute), 100)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if removed != 2 || fmt.Sprint(snapshots) != "[event-0.jpg]" {
+		t.Fatalf("удалено %d, снимки %v", removed, snapshots)
+	}
+
+	// По количеству: удаляются самые старые записи сверх лимита
+	removed, snapshots, err = prune(baseTime, 2)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if removed != 2 || fmt.Sprint(snapshots) != "[event-3.jpg]" {
+		t.Fatalf("удалено %d, снимки %v", removed, snapshots)
+	}
+
+	if pages := queryAll(t, Filter{}, 10); fmt.Sprint(pages) != "[[event-5 event-4]]" {
+		t.Fatalf("записи после очистки %v", pages)
+	}
+	// Индекс по ID очищается вместе с записями
+	for _, id := range []string{"event-0", "event-3"} {
+		if _, err := Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("запись %s осталась в индексе: %v", id, err)
+		}
+	}
+}
+
+func TestCloseActive(t *testing.T) {
+	openTestDB(t)
+	insertRecords(t, 3)
+	if err := update("event-1", func(r *Record) { r.Active = true }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := closeActive(); err != nil {
+		t.Fatalf("closeActive: %v", err)
+	}
+
+	records, _, err := Query(Filter{}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("записей после closeActive: %d", len(records))
+	}
+	for _, r := range records {
+		if r.Active {
+			t.Errorf("запись %s осталась активной", r.ID)
+		}
+	}
+	if record, err := Get("event-1"); err != nil || record.Active {
+		t.Fatalf("запись по индексу %+v, %v", record, err)
+	}
+}
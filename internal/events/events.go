@@ -0,0 +1,127 @@
+// internal/events/events.go
+package events
+
+import (
+	"TeleOko/internal/config"
+	"TeleOko/internal/hikvision"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// subscriberBuffer - сколько событий копится для медленного подписчика
+const subscriberBuffer = 64
+
+// Системные события TeleOko
+const (
+	// SystemDevice - источник системных событий
+	SystemDevice = "teleoko"
+	// TypeGo2RTCDown - go2rtc упал (active) или перезапущен (inactive)
+	TypeGo2RTCDown = "go2rtcdown"
+)
+
+var (
+	subscribers = make(map[chan hikvision.Event]struct{})
+	mu          sync.Mutex
+	stopped     bool
+)
+
+// Start подписывается на события всех устройств Hikvision, если подписка
+// включена в настройках. При отмене ctx подписки на устройствах и каналы
+// подписчиков закрываются.
+func Start(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if !config.IsEventsEnabled() {
+		log.Println("⚠️ Подписка на события устройств отключена")
+		return
+	}
+
+	for _, device := range config.GetDevices() {
+		if device.Vendor != config.VendorHikvision {
+			continue
+		}
+		go hikvision.RunEventSubscriber(ctx, device.ID, Publish)
+	}
+	log.Println("📡 Подписка на события устройств включена")
+}
+
+// Publish рассылает событие всем подписчикам. Если подписчик не успевает
+// читать, событие для него теряется, чтобы не задерживать остальных.
+func Publish(event hikvision.Event) {
+	log.Printf("🔔 Событие %s (%s) устройства %s, канал %s", event.Type, event.State, event.Device, event.Channel)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for ch := range subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("⚠️ Подписчик не успевает получать события, событие %s пропущено", event.ID)
+		}
+	}
+}
+
+// PublishSystem рассылает системное событие TeleOko (без канала)
+func PublishSystem(eventType, state, description string) {
+	Publish(hikvision.Event{
+		ID:          uuid.New().String(),
+		Device:      SystemDevice,
+		Type:        eventType,
+		RawType:     eventType,
+		State:       state,
+		Description: description,
+		Time:        time.Now(),
+	})
+}
+
+// Subscribe возвращает канал событий и функцию отписки
+func Subscribe() (<-chan hikvision.Event, func()) {
+	return SubscribeBuffered(subscriberBuffer)
+}
+
+// SubscribeBuffered подписывает с указанным размером очереди, например
+// для журнала, который не должен терять события при всплесках
+func SubscribeBuffered(size int) (<-chan hikvision.Event, func()) {
+	ch := make(chan hikvision.Event, size)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if stopped {
+		close(ch)
+		return ch, func() {}
+	}
+	subscribers[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := subscribers[ch]; ok {
+				delete(subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+// stop закрывает каналы всех подписчиков
+func stop() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	stopped = true
+	for ch := range subscribers {
+		delete(subscribers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,349 @@
+// internal/export/export.go
+package export
+
+import (
+	"TeleOko/internal/config"
+	"TeleOko/internal/go2rtc"
+	"TeleOko/internal/hikvision"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// cacheTTL - сколько выгруженный клип хранится для повторных
+	// запросов Range (докачка, перемотка в плеере браузера)
+	cacheTTL = 10 * time.Minute
+	// downloadTimeoutMargin - запас времени выгрузки сверх длительности
+	// клипа: go2rtc получает архив от регистратора в реальном времени
+	downloadTimeoutMargin = 10 * time.Minute
+)
+
+// ErrEmptyClip - устройство не вернуло данных за интервал
+var ErrEmptyClip = errors.New("запись за указанный интервал пуста")
+
+// Clip - выгруженный или еще выгружаемый файл записи
+type Clip struct {
+	Path    string
+	Name    string // имя файла для браузера
+	Size    int64
+	ModTime time.Time
+
+	job *job // выгрузка продолжается, клип передается по мере получения
+}
+
+// job - выгрузка клипа; общая для одновременных запросов одного интервала
+type job struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	aborted bool // все ожидающие запросы отменены
+	clip    *Clip
+	err     error
+
+	path     string
+	written  int64         // сколько байт записано в path (под jobsMu)
+	progress chan struct{} // закрывается при каждой записи (под jobsMu)
+}
+
+var (
+	jobs      = make(map[string]*job)
+	jobsMu    sync.Mutex
+	cacheOnce sync.Once
+)
+
+// cacheDir возвращает каталог временных файлов выгрузки
+func cacheDir() string {
+	return filepath.Join(os.TempDir(), "teleoko-export")
+}
+
+// Get возвращает клип канала за интервал, выгружая его при необходимости.
+// Get ждет первых данных клипа: если выгрузка еще идет, возвращается
+// незавершенный клип, который передается через Stream и освобождается
+// через Close. Если все ожидающие запросы отменены (клиенты отключились),
+// выгрузка прерывается и временный файл удаляется.
+func Get(ctx context.Context, channelID string, startTime, endTime time.Time) (*Clip, error) {
+	cacheOnce.Do(func() {
+		// Клипы прошлого запуска недействительны
+		os.RemoveAll(cacheDir())
+	})
+
+	if err := os.MkdirAll(cacheDir(), 0700); err != nil {
+		return nil, fmt.Errorf("ошибка создания каталога выгрузки: %v", err)
+	}
+
+	key := fmt.Sprintf("%s|%d|%d", channelID, startTime.Unix(), endTime.Unix())
+
+	jobsMu.Lock()
+	cleanupLocked()
+	j, ok := jobs[key]
+	if !ok || j.aborted {
+		file, err := os.CreateTemp(cacheDir(), "clip-*.mp4")
+		if err != nil {
+			jobsMu.Unlock()
+			return nil, fmt.Errorf("ошибка создания файла: %v", err)
+		}
+
+		jobCtx, cancel := context.WithCancel(context.Background())
+		j = &job{done: make(chan struct{}), cancel: cancel, path: file.Name(), progress: make(chan struct{})}
+		jobs[key] = j
+		go j.run(jobCtx, key, channelID, startTime, endTime, file)
+	}
+	j.waiters++
+
+	for {
+		switch {
+		case j.isDone():
+			j.waiters--
+			jobsMu.Unlock()
+			return j.clip, j.err
+		case j.written > 0:
+			jobsMu.Unlock()
+			return &Clip{
+				Path: j.path,
+				Name: fileName(channelID, startTime, endTime),
+				job:  j,
+			}, nil
+		}
+
+		progress := j.progress
+		jobsMu.Unlock()
+
+		select {
+		case <-progress:
+		case <-j.done:
+		case <-ctx.Done():
+			jobsMu.Lock()
+			j.release()
+			jobsMu.Unlock()
+			return nil, ctx.Err()
+		}
+
+		jobsMu.Lock()
+	}
+}
+
+// Complete сообщает, что клип выгружен полностью и поддерживает Range
+func (c *Clip) Complete() bool {
+	return c.job == nil
+}
+
+// Stream передает незавершенный клип в w по мере выгрузки до ее окончания
+func (c *Clip) Stream(ctx context.Context, w io.Writer) (int64, error) {
+	if c.job == nil {
+		return 0, errors.New("клип выгружен полностью")
+	}
+
+	file, err := os.Open(c.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	flusher, _ := w.(interface{ Flush() })
+	var sent int64
+	for {
+		jobsMu.Lock()
+		written, progress, done := c.job.written, c.job.progress, c.job.isDone()
+		jobErr := c.job.err
+		jobsMu.Unlock()
+
+		if sent < written {
+			n, err := io.Copy(w, io.NewSectionReader(file, sent, written-sent))
+			sent += n
+			if err != nil {
+				return sent, err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			continue
+		}
+		if done {
+			return sent, jobErr
+		}
+
+		select {
+		case <-progress:
+		case <-c.job.done:
+		case <-ctx.Done():
+			return sent, ctx.Err()
+		}
+	}
+}
+
+// Close освобождает незавершенный клип. Когда отключается последний
+// получатель, выгрузка отменяется.
+func (c *Clip) Close() {
+	if c.job == nil {
+		return
+	}
+
+	jobsMu.Lock()
+	c.job.release()
+	jobsMu.Unlock()
+	c.job = nil
+}
+
+// release снимает ожидающий запрос и отменяет выгрузку без получателей
+// (вызывается под jobsMu)
+func (j *job) release() {
+	j.waiters--
+	if j.waiters == 0 && !j.isDone() {
+		j.aborted = true
+		j.cancel()
+	}
+}
+
+// isDone сообщает, что выгрузка завершена
+func (j *job) isDone() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run выгружает клип во временный файл
+func (j *job) run(ctx context.Context, key, channelID string, startTime, endTime time.Time, file *os.File) {
+	defer j.cancel()
+
+	clip, err := download(ctx, channelID, startTime, endTime, &progressWriter{file: file, job: j})
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	defer close(j.done)
+	if clip != nil {
+		clip.Path = j.path
+	}
+	j.clip, j.err = clip, err
+	switch {
+	case err != nil && jobs[key] == j:
+		// Неудачная выгрузка не кешируется
+		delete(jobs, key)
+	case err == nil && jobs[key] != j:
+		// Выгрузку уже заменила новая, файл никому не нужен
+		os.Remove(j.path)
+	}
+}
+
+// progressWriter записывает клип в файл и сообщает получателям о новых данных
+type progressWriter struct {
+	file *os.File
+	job  *job
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	if n > 0 {
+		jobsMu.Lock()
+		w.job.written += int64(n)
+		close(w.job.progress)
+		w.job.progress = make(chan struct{})
+		jobsMu.Unlock()
+	}
+	return n, err
+}
+
+// written возвращает, сколько байт уже записано
+func (w *progressWriter) written() int64 {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return w.job.written
+}
+
+// download выгружает запись через ISAPI, а если регистратор не поддерживает
+// выгрузку - через MP4 поток go2rtc
+func download(ctx context.Context, channelID string, startTime, endTime time.Time, w *progressWriter) (*Clip, error) {
+	log.Printf("⬇️ ВЫГРУЗКА - Канал %s, %s - %s", channelID, startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+
+	// Каждый способ выгрузки ограничен длительностью клипа с запасом
+	timeout := endTime.Sub(startTime) + downloadTimeoutMargin
+
+	isapiCtx, cancel := context.WithTimeout(ctx, timeout)
+	size, err := hikvision.DownloadClip(isapiCtx, channelID, startTime, endTime, w)
+	cancel()
+
+	// Переключаться на go2rtc можно, только пока получателям ничего не отдано
+	if err != nil && !errors.Is(err, context.Canceled) && w.written() == 0 && config.IsGo2RTCEnabled() {
+		log.Printf("  ⚠️ Выгрузка через ISAPI недоступна, используется go2rtc: %v", err)
+		go2rtcCtx, cancel := context.WithTimeout(ctx, timeout)
+		size, err = downloadViaGo2RTC(go2rtcCtx, channelID, startTime, endTime, w)
+		cancel()
+	}
+	if err == nil && size == 0 {
+		err = ErrEmptyClip
+	}
+
+	closeErr := w.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(w.file.Name())
+		if errors.Is(ctx.Err(), context.Canceled) {
+			log.Printf("  ⏹️ Выгрузка канала %s отменена", channelID)
+			return nil, context.Canceled
+		}
+		log.Printf("  ❌ Ошибка выгрузки канала %s: %v", channelID, err)
+		return nil, err
+	}
+
+	log.Printf("  ✅ Клип канала %s выгружен: %d байт", channelID, size)
+	return &Clip{
+		Name:    fileName(channelID, startTime, endTime),
+		Size:    size,
+		ModTime: time.Now(),
+	}, nil
+}
+
+// downloadViaGo2RTC записывает архив через временный поток go2rtc
+func downloadViaGo2RTC(ctx context.Context, channelID string, startTime, endTime time.Time, w io.Writer) (int64, error) {
+	playbackURL, err := hikvision.GetPlaybackURL(ctx, channelID, startTime, endTime)
+	if err != nil {
+		return 0, err
+	}
+
+	// Клип общий для всех пользователей, поэтому у потока нет владельца
+	// и остановить его через API может только администратор
+	streamID := go2rtc.PlaybackStreamPrefix + "export_" + uuid.New().String()
+	if err := go2rtc.RegisterPlaybackStream(streamID, playbackURL, ""); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := go2rtc.ReleasePlaybackStream(streamID); err != nil {
+			log.Printf("  ⚠️ Ошибка удаления потока %s: %v", streamID, err)
+		}
+	}()
+
+	return go2rtc.DownloadMP4(ctx, streamID, endTime.Sub(startTime), w)
+}
+
+// cleanupLocked удаляет клипы старше cacheTTL (вызывается под jobsMu)
+func cleanupLocked() {
+	for key, j := range jobs {
+		if j.clip != nil && time.Since(j.clip.ModTime) > cacheTTL && j.waiters == 0 {
+			os.Remove(j.clip.Path)
+			delete(jobs, key)
+		}
+	}
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// fileName формирует имя файла клипа для браузера
+func fileName(channelID string, startTime, endTime time.Time) string {
+	return fmt.Sprintf("teleoko_%s_%s_%s.mp4",
+		unsafeNameChars.ReplaceAllString(channelID, "_"),
+		startTime.Format("20060102_150405"), endTime.Format("20060102_150405"))
+}
@@ -0,0 +1,139 @@
+// internal/export/export_test.go
+package export
+
+import (
+	"TeleOko/internal/config"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeNVR запускает сервер вместо регистратора и настраивает на него канал 101
+func newFakeNVR(t *testing.T, download http.HandlerFunc) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ISAPI/ContentMgmt/download" {
+			http.NotFound(w, r)
+			return
+		}
+		download(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := config.GlobalConfig
+	config.GlobalConfig.Go2RTC.Enabled = false
+	config.GlobalConfig.Devices = []config.Device{{
+		ID:       "nvr",
+		Vendor:   config.VendorHikvision,
+		IP:       "127.0.0.1",
+		HTTPPort: server.Listener.Addr().(*net.TCPAddr).Port,
+	}}
+	config.GlobalConfig.Channels = []config.Channel{{ID: "101", Device: "nvr"}}
+	t.Cleanup(func() { config.GlobalConfig = previous })
+}
+
+var clipCounter atomic.Int64
+
+// clipRange возвращает новый интервал клипа, чтобы тесты не делили выгрузки
+func clipRange() (time.Time, time.Time) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Add(time.Duration(clipCounter.Add(1)) * time.Hour)
+	return start, start.Add(time.Minute)
+}
+
+func TestGetStreamsClipWhileDownloading(t *testing.T) {
+	release := make(chan struct{})
+	newFakeNVR(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("second"))
+	})
+	start, end := clipRange()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Первые данные доступны, пока регистратор еще отдает запись
+	clip, err := Get(ctx, "101", start, end)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if clip.Complete() {
+		t.Fatal("клип выгружен до окончания ответа регистратора")
+	}
+
+	var buf bytes.Buffer
+	streamed := make(chan error, 1)
+	go func() {
+		_, err := clip.Stream(ctx, &buf)
+		streamed <- err
+	}()
+
+	close(release)
+	if err := <-streamed; err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	clip.Close()
+	if buf.String() != "firstsecond" {
+		t.Fatalf("передано %q", buf.String())
+	}
+
+	// Повторный запрос получает выгруженный клип с поддержкой Range
+	cached, err := Get(ctx, "101", start, end)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !cached.Complete() || cached.Size != int64(len("firstsecond")) {
+		t.Fatalf("клип из кеша %+v", cached)
+	}
+}
+
+func TestGetEmptyClip(t *testing.T) {
+	newFakeNVR(t, func(w http.ResponseWriter, r *http.Request) {})
+	start, end := clipRange()
+
+	if _, err := Get(context.Background(), "101", start, end); !errors.Is(err, ErrEmptyClip) {
+		t.Fatalf("ожидалась ErrEmptyClip, получено %v", err)
+	}
+}
+
+func TestCloseLastReceiverCancelsDownload(t *testing.T) {
+	var cancelled atomic.Bool
+	newFakeNVR(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		cancelled.Store(true)
+	})
+	start, end := clipRange()
+
+	clip, err := Get(context.Background(), "101", start, end)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	path := clip.Path
+	clip.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cancelled.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("выгрузка не отменена после отключения получателя")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	jobsMu.Lock()
+	for _, j := range jobs {
+		if j.path == path && !j.aborted {
+			t.Error("выгрузка осталась в кеше")
+		}
+	}
+	jobsMu.Unlock()
+}
@@ -0,0 +1,129 @@
+// internal/go2rtc/api.go
+package go2rtc
+
+import (
+	"TeleOko/internal/config"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Ошибки API go2rtc
+var (
+	ErrStreamNotFound = errors.New("поток не найден в go2rtc")
+	ErrTimeout        = errors.New("go2rtc не ответил вовремя")
+	ErrUnavailable    = errors.New("go2rtc недоступен")
+)
+
+// SessionDescription - SDP предложение или ответ WebRTC
+type SessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// apiClient используется для всех запросов к API go2rtc
+var apiClient = &http.Client{Timeout: 10 * time.Second}
+
+// pingClient - клиент с коротким таймаутом для проверки готовности
+var pingClient = &http.Client{Timeout: time.Second}
+
+// APIBaseURL возвращает базовый URL API go2rtc
+func APIBaseURL() string {
+	return fmt.Sprintf("http://localhost:%d", config.GetGo2RTCPort())
+}
+
+// Ping проверяет, что API go2rtc отвечает
+func Ping() error {
+	resp, err := pingClient.Get(APIBaseURL() + "/api")
+	if err != nil {
+		return wrapRequestError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ошибка go2rtc HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ExchangeSDP передает SDP предложение браузера в go2rtc и возвращает его ответ
+func ExchangeSDP(src string, offer SessionDescription) (*SessionDescription, error) {
+	if offer.Type == "" {
+		offer.Type = "offer"
+	}
+
+	body, err := json.Marshal(offer)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации SDP: %v", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/webrtc?src=%s", APIBaseURL(), url.QueryEscape(src))
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, wrapRequestError(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа go2rtc: %v", err)
+	}
+
+	if err := checkStatus(resp.StatusCode, respBody); err != nil {
+		return nil, err
+	}
+
+	// go2rtc отвечает JSON, если запрос был в JSON, но старые версии
+	// возвращают чистый SDP
+	var answer SessionDescription
+	if err := json.Unmarshal(respBody, &answer); err != nil || answer.SDP == "" {
+		sdp := strings.TrimSpace(string(respBody))
+		if !strings.HasPrefix(sdp, "v=") {
+			return nil, fmt.Errorf("некорректный SDP ответ go2rtc: %s", sdp)
+		}
+		answer = SessionDescription{Type: "answer", SDP: sdp}
+	}
+	if answer.Type == "" {
+		answer.Type = "answer"
+	}
+
+	return &answer, nil
+}
+
+// checkStatus преобразует HTTP статус go2rtc в ошибку
+func checkStatus(statusCode int, body []byte) error {
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+
+	// Текст ошибки go2rtc меняется между версиями, поэтому важен только статус
+	message := strings.TrimSpace(string(body))
+	if statusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrStreamNotFound, message)
+	}
+
+	return fmt.Errorf("ошибка go2rtc HTTP %d: %s", statusCode, message)
+}
+
+// wrapRequestError классифицирует сетевые ошибки запроса к go2rtc
+func wrapRequestError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
@@ -0,0 +1,119 @@
+// internal/go2rtc/api_test.go
+package go2rtc
+
+import (
+	"TeleOko/internal/config"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeGo2RTC запускает HTTP сервер вместо API go2rtc и направляет
+// на него запросы пакета
+func newFakeGo2RTC(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	previous := config.GlobalConfig.Go2RTC.Port
+	config.GlobalConfig.Go2RTC.Port = server.Listener.Addr().(*net.TCPAddr).Port
+	t.Cleanup(func() { config.GlobalConfig.Go2RTC.Port = previous })
+	return server
+}
+
+func TestExchangeSDPJSONAnswer(t *testing.T) {
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/webrtc" {
+			t.Errorf("запрос %s %s, ожидался POST /api/webrtc", r.Method, r.URL.Path)
+		}
+		if src := r.URL.Query().Get("src"); src != "101" {
+			t.Errorf("src = %q, ожидался 101", src)
+		}
+
+		var offer SessionDescription
+		if err := json.NewDecoder(r.Body).Decode(&offer); err != nil {
+			t.Errorf("ошибка разбора предложения: %v", err)
+		}
+		if offer.Type != "offer" || offer.SDP != "v=0 offer" {
+			t.Errorf("предложение %+v", offer)
+		}
+
+		json.NewEncoder(w).Encode(SessionDescription{Type: "answer", SDP: "v=0 answer"})
+	}))
+
+	answer, err := ExchangeSDP("101", SessionDescription{SDP: "v=0 offer"})
+	if err != nil {
+		t.Fatalf("ExchangeSDP: %v", err)
+	}
+	if answer.Type != "answer" || answer.SDP != "v=0 answer" {
+		t.Fatalf("ответ %+v", answer)
+	}
+}
+
+func TestExchangeSDPRawAnswer(t *testing.T) {
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\n")
+	}))
+
+	answer, err := ExchangeSDP("101", SessionDescription{Type: "offer", SDP: "v=0"})
+	if err != nil {
+		t.Fatalf("ExchangeSDP: %v", err)
+	}
+	if answer.Type != "answer" || answer.SDP != "v=0\r\no=- 0 0 IN IP4 127.0.0.1" {
+		t.Fatalf("ответ %+v", answer)
+	}
+}
+
+func TestExchangeSDPInvalidAnswer(t *testing.T) {
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html>ok</html>")
+	}))
+
+	if _, err := ExchangeSDP("101", SessionDescription{SDP: "v=0"}); err == nil {
+		t.Fatal("ожидалась ошибка для ответа без SDP")
+	}
+}
+
+func TestExchangeSDPStatusErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		notFound bool
+	}{
+		{name: "404", status: http.StatusNotFound, body: "stream not found", notFound: true},
+		{name: "500 с текстом not found", status: http.StatusInternalServerError, body: "codec not found"},
+		{name: "400", status: http.StatusBadRequest, body: "bad offer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, tt.body, tt.status)
+			}))
+
+			_, err := ExchangeSDP("101", SessionDescription{SDP: "v=0"})
+			if err == nil {
+				t.Fatal("ожидалась ошибка")
+			}
+			if got := errors.Is(err, ErrStreamNotFound); got != tt.notFound {
+				t.Fatalf("errors.Is(ErrStreamNotFound) = %v, ожидалось %v (%v)", got, tt.notFound, err)
+			}
+		})
+	}
+}
+
+func TestExchangeSDPUnavailable(t *testing.T) {
+	server := newFakeGo2RTC(t, http.NotFoundHandler())
+	server.Close()
+
+	_, err := ExchangeSDP("101", SessionDescription{SDP: "v=0"})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("ожидалась ErrUnavailable, получено %v", err)
+	}
+}
@@ -3,7 +3,10 @@ package go2rtc
 
 import (
 	"TeleOko/internal/config"
+	"TeleOko/internal/redact"
 	"archive/zip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,12 +16,25 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 const (
 	go2rtcVersion = "1.9.9"
 	go2rtcRepo    = "AlexxIT/go2rtc"
+
+	// Параметры перезапуска go2rtc после падения
+	minRestartDelay   = 1 * time.Second
+	maxRestartDelay   = 1 * time.Minute
+	backoffResetAfter = 1 * time.Minute
+
+	// Время на корректное завершение go2rtc перед SIGKILL
+	stopGracePeriod = 5 * time.Second
+
+	// Период опроса API go2rtc при запуске
+	readyPollInterval = 200 * time.Millisecond
 )
 
 // Manager управляет процессом go2rtc
@@ -27,6 +43,25 @@ type Manager struct {
 	configPath string
 	binaryPath string
 	isRunning  bool
+
+	mu             sync.Mutex
+	stopCh         chan struct{} // закрывается при остановке менеджера
+	exited         chan struct{} // закрывается при завершении текущего процесса
+	restartCount   int
+	lastExitReason string
+	lastExitAt     time.Time
+
+	// onStatus вызывается при падении (running = false) и перезапуске go2rtc
+	onStatus func(running bool, reason string)
+}
+
+// Status - состояние процесса go2rtc для мониторинга
+type Status struct {
+	Running        bool       `json:"running"`
+	PID            int        `json:"pid,omitempty"`
+	Restarts       int        `json:"restarts"`
+	LastExitReason string     `json:"last_exit_reason,omitempty"`
+	LastExitAt     *time.Time `json:"last_exit_at,omitempty"`
 }
 
 var manager *Manager
@@ -42,6 +77,24 @@ func NewManager() *Manager {
 	return manager
 }
 
+// SetStatusHandler задает обработчик падений и перезапусков go2rtc
+func (m *Manager) SetStatusHandler(fn func(running bool, reason string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onStatus = fn
+}
+
+// notifyStatus передает изменение состояния обработчику
+func (m *Manager) notifyStatus(running bool, reason string) {
+	m.mu.Lock()
+	fn := m.onStatus
+	m.mu.Unlock()
+
+	if fn != nil {
+		fn(running, reason)
+	}
+}
+
 // Start запускает go2rtc
 func (m *Manager) Start() error {
 	// Проверяем, установлен ли go2rtc
@@ -68,6 +121,10 @@ func (m *Manager) Start() error {
 		return fmt.Errorf("ошибка создания конфигурации go2rtc: %v", err)
 	}
 
+	m.mu.Lock()
+	m.stopCh = make(chan struct{})
+	m.mu.Unlock()
+
 	// Запускаем процесс
 	if err := m.startProcess(); err != nil {
 		return fmt.Errorf("ошибка запуска go2rtc: %v", err)
@@ -77,23 +134,93 @@ func (m *Manager) Start() error {
 	return nil
 }
 
-// Stop останавливает go2rtc
+// Stop останавливает go2rtc и его супервизор, давая процессу
+// stopGracePeriod на корректное завершение
 func (m *Manager) Stop() error {
-	if m.process != nil && m.isRunning {
-		if err := m.process.Process.Kill(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), stopGracePeriod)
+	defer cancel()
+	return m.Shutdown(ctx)
+}
+
+// Shutdown корректно останавливает go2rtc: отправляет SIGTERM и ждет
+// завершения процесса до истечения ctx, после чего завершает его SIGKILL
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.halt()
+	process := m.process
+	running := m.isRunning
+	exited := m.exited
+	m.mu.Unlock()
+
+	if process == nil || !running {
+		return nil
+	}
+
+	// На Windows SIGTERM не поддерживается - сразу переходим к Kill
+	if err := process.Process.Signal(syscall.SIGTERM); err != nil {
+		log.Printf("SIGTERM для go2rtc не доставлен (%v), принудительная остановка", err)
+	} else {
+		select {
+		case <-exited:
+			log.Println("go2rtc остановлен")
+			return nil
+		case <-ctx.Done():
+			log.Println("⚠️ go2rtc не завершился вовремя, принудительная остановка")
+		}
+	}
+
+	// Процесс мог завершиться сам между ctx.Done и Kill - это не ошибка
+	if err := process.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		select {
+		case <-exited:
+		default:
 			return fmt.Errorf("ошибка остановки go2rtc: %v", err)
 		}
-		m.isRunning = false
-		log.Println("go2rtc остановлен")
 	}
+	<-exited
+	log.Println("go2rtc остановлен")
 	return nil
 }
 
 // IsRunning проверяет, запущен ли go2rtc
 func (m *Manager) IsRunning() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.isRunning
 }
 
+// Status возвращает состояние процесса go2rtc
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := Status{
+		Running:        m.isRunning,
+		Restarts:       m.restartCount,
+		LastExitReason: m.lastExitReason,
+	}
+	if m.isRunning && m.process != nil && m.process.Process != nil {
+		status.PID = m.process.Process.Pid
+	}
+	if !m.lastExitAt.IsZero() {
+		lastExitAt := m.lastExitAt
+		status.LastExitAt = &lastExitAt
+	}
+	return status
+}
+
+// halt помечает менеджер остановленным (вызывается под m.mu)
+func (m *Manager) halt() {
+	if m.stopCh == nil {
+		return
+	}
+	select {
+	case <-m.stopCh:
+	default:
+		close(m.stopCh)
+	}
+}
+
 // GetAPIURL возвращает URL для API go2rtc
 func (m *Manager) GetAPIURL() string {
 	return fmt.Sprintf("http://localhost:%d", config.GetGo2RTCPort())
@@ -274,19 +401,79 @@ func (m *Manager) createConfig() error {
 		configContent += fmt.Sprintf("  %s: %s\n", channel.ID, channel.URL)
 	}
 
+	// go2rtc не проверяет авторизацию, поэтому слушает только локальный
+	// адрес: клиенты подключаются через API TeleOko с проверкой прав
 	configContent += "\nwebrtc:\n"
-	configContent += fmt.Sprintf("  listen: :%d\n", config.GetGo2RTCPort())
+	configContent += fmt.Sprintf("  listen: 127.0.0.1:%d\n", config.GetGo2RTCPort())
 	configContent += "  candidates:\n"
 	configContent += "    - stun:stun.l.google.com:19302\n"
 
 	configContent += "\napi:\n"
-	configContent += fmt.Sprintf("  listen: :%d\n", config.GetGo2RTCPort())
+	configContent += fmt.Sprintf("  listen: 127.0.0.1:%d\n", config.GetGo2RTCPort())
 
 	return os.WriteFile(m.configPath, []byte(configContent), 0644)
 }
 
-// startProcess запускает процесс go2rtc
+// startProcess запускает процесс go2rtc под наблюдением супервизора
 func (m *Manager) startProcess() error {
+	cmd, err := m.spawn()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	exited := m.exited
+	m.mu.Unlock()
+
+	go m.supervise(cmd)
+
+	// Ждем, пока API go2rtc начнет отвечать
+	if err := waitReady(exited, config.GetGo2RTCStartTimeout()); err != nil {
+		m.mu.Lock()
+		m.halt()
+		process := m.process
+		running := m.isRunning
+		m.mu.Unlock()
+
+		if running {
+			process.Process.Kill()
+			<-exited
+		}
+		return err
+	}
+
+	log.Printf("✅ go2rtc запущен (PID: %d)", cmd.Process.Pid)
+	return nil
+}
+
+// waitReady опрашивает API go2rtc до первого успешного ответа.
+// Возвращает ошибку, если процесс завершился или истек timeout.
+func waitReady(exited <-chan struct{}, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+
+	started := time.Now()
+	var lastErr error
+	for {
+		if lastErr = Ping(); lastErr == nil {
+			log.Printf("go2rtc готов за %v", time.Since(started).Round(time.Millisecond))
+			return nil
+		}
+
+		select {
+		case <-exited:
+			return fmt.Errorf("go2rtc завершился во время запуска")
+		case <-deadline.C:
+			return fmt.Errorf("go2rtc не ответил за %v: %v", timeout, lastErr)
+		case <-ticker.C:
+		}
+	}
+}
+
+// spawn создает и запускает процесс go2rtc
+func (m *Manager) spawn() (*exec.Cmd, error) {
 	// Получаем абсолютный путь к go2rtc
 	absPath, err := filepath.Abs(m.binaryPath)
 	if err != nil {
@@ -301,31 +488,127 @@ func (m *Manager) startProcess() error {
 	log.Printf("🚀 Запуск go2rtc: %s", absPath)
 
 	// Создаем команду с абсолютным путем
-	m.process = exec.Command(absPath, "-config", m.configPath)
+	cmd := exec.Command(absPath, "-config", m.configPath)
 
-	// Перенаправляем логи
-	m.process.Stdout = os.Stdout
-	m.process.Stderr = os.Stderr
+	// Перенаправляем логи, скрывая пароли камер
+	cmd.Stdout = redact.NewWriter(os.Stdout)
+	cmd.Stderr = redact.NewWriter(os.Stderr)
 
-	if err := m.process.Start(); err != nil {
-		return fmt.Errorf("не удалось запустить %s: %v", absPath, err)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("не удалось запустить %s: %v", absPath, err)
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Менеджер мог быть остановлен, пока процесс запускался
+	select {
+	case <-m.stopCh:
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("менеджер go2rtc остановлен")
+	default:
+	}
+
+	m.process = cmd
 	m.isRunning = true
+	m.exited = make(chan struct{})
+
+	return cmd, nil
+}
 
-	// Ждем немного, чтобы процесс запустился
-	time.Sleep(3 * time.Second)
+// supervise ждет завершения go2rtc и перезапускает его с нарастающей задержкой
+func (m *Manager) supervise(cmd *exec.Cmd) {
+	delay := minRestartDelay
 
-	// Проверяем, что процесс еще работает
-	if m.process.ProcessState != nil && m.process.ProcessState.Exited() {
+	for {
+		startedAt := time.Now()
+		err := cmd.Wait()
+		reason := describeExit(cmd, err)
+
+		m.mu.Lock()
 		m.isRunning = false
-		return fmt.Errorf("go2rtc завершился сразу после запуска")
+		m.lastExitReason = reason
+		m.lastExitAt = time.Now()
+		close(m.exited)
+		stopCh := m.stopCh
+		m.mu.Unlock()
+
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		log.Printf("💥 go2rtc завершился: %s", reason)
+		m.notifyStatus(false, reason)
+
+		// Если процесс проработал долго, начинаем отсчет задержки заново
+		if time.Since(startedAt) > backoffResetAfter {
+			delay = minRestartDelay
+		}
+
+		for {
+			log.Printf("🔁 Перезапуск go2rtc через %v", delay)
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+			if delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+
+			cmd, err = m.spawn()
+			if err != nil {
+				log.Printf("⚠️ Ошибка перезапуска go2rtc: %v", err)
+				continue
+			}
+
+			m.mu.Lock()
+			m.restartCount++
+			exited := m.exited
+			m.mu.Unlock()
+
+			// go2rtc читает go2rtc.yaml, записанный при старте, поэтому каналы
+			// и временные потоки архива восстанавливаются через API
+			if err := m.restoreStreams(exited); err != nil {
+				log.Printf("⚠️ Потоки go2rtc не восстановлены после перезапуска: %v", err)
+			}
+
+			log.Printf("✅ go2rtc перезапущен (PID: %d)", cmd.Process.Pid)
+			m.notifyStatus(true, fmt.Sprintf("перезапущен, PID %d", cmd.Process.Pid))
+			break
+		}
 	}
+}
 
-	log.Printf("✅ go2rtc запущен (PID: %d)", m.process.Process.Pid)
+// restoreStreams ждет готовности перезапущенного go2rtc, синхронизирует
+// потоки с текущей конфигурацией и возвращает временные потоки архива
+func (m *Manager) restoreStreams(exited <-chan struct{}) error {
+	if err := waitReady(exited, config.GetGo2RTCStartTimeout()); err != nil {
+		return err
+	}
+	if _, err := m.UpdateStreams(); err != nil {
+		return err
+	}
+	restorePlaybackStreams()
 	return nil
 }
 
+// describeExit формирует описание завершения процесса
+func describeExit(cmd *exec.Cmd, err error) string {
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.String()
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return "неизвестная причина"
+}
+
 // getGo2RTCBinaryPath возвращает путь к бинарнику go2rtc
 func getGo2RTCBinaryPath() string {
 	if runtime.GOOS == "windows" {
@@ -334,15 +617,81 @@ func getGo2RTCBinaryPath() string {
 	return "go2rtc"
 }
 
-// UpdateStreams обновляет потоки в go2rtc
-func (m *Manager) UpdateStreams() error {
-	if !m.isRunning {
-		return fmt.Errorf("go2rtc не запущен")
+// Действия синхронизации потоков
+const (
+	StreamAdded     = "added"
+	StreamUpdated   = "updated"
+	StreamRemoved   = "removed"
+	StreamUnchanged = "unchanged"
+)
+
+// StreamChange описывает изменение одного потока при синхронизации
+type StreamChange struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// UpdateStreams синхронизирует потоки go2rtc с каналами из конфигурации
+func (m *Manager) UpdateStreams() ([]StreamChange, error) {
+	if !m.IsRunning() {
+		return nil, fmt.Errorf("go2rtc не запущен")
+	}
+
+	existing, err := ListStreams()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка потоков: %w", err)
 	}
 
-	// Пока просто логируем - в реальной реализации здесь API вызовы
 	channels := config.GetChannels()
-	log.Printf("Обновление %d потоков в go2rtc", len(channels))
+	log.Printf("Синхронизация %d каналов с %d потоками go2rtc", len(channels), len(existing))
 
-	return nil
+	changes := make([]StreamChange, 0, len(channels))
+	wanted := make(map[string]bool, len(channels))
+
+	for _, channel := range channels {
+		wanted[channel.ID] = true
+		change := StreamChange{Name: channel.ID, Action: StreamUnchanged}
+
+		info, ok := existing[channel.ID]
+		switch {
+		case !ok || info == nil:
+			change.Action = StreamAdded
+			err = AddStream(channel.ID, channel.URL)
+		case info.Source() != channel.URL:
+			change.Action = StreamUpdated
+			err = PatchStream(channel.ID, channel.URL)
+		default:
+			err = nil
+		}
+
+		if err != nil {
+			change.Error = err.Error()
+		}
+		changes = append(changes, change)
+	}
+
+	// Удаляем потоки, которых больше нет в конфигурации.
+	// Временные потоки архива не трогаем.
+	for name := range existing {
+		if wanted[name] || IsPlaybackStream(name) {
+			continue
+		}
+
+		change := StreamChange{Name: name, Action: StreamRemoved}
+		if err := DeleteStream(name); err != nil {
+			change.Error = err.Error()
+		}
+		changes = append(changes, change)
+	}
+
+	for _, change := range changes {
+		if change.Error != "" {
+			log.Printf("  ⚠️ [%s] %s: %s", change.Name, change.Action, change.Error)
+		} else if change.Action != StreamUnchanged {
+			log.Printf("  🔄 [%s] %s", change.Name, change.Action)
+		}
+	}
+
+	return changes, nil
 }
@@ -0,0 +1,285 @@
+// internal/go2rtc/manager_test.go
+package go2rtc
+
+import (
+	"TeleOko/internal/config"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeBinary создает скрипт вместо go2rtc
+func newFakeBinary(t *testing.T, script string) *Manager {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("скрипт вместо go2rtc требует sh")
+	}
+
+	dir := t.TempDir()
+	binaryPath := filepath.Join(dir, "go2rtc")
+	if err := os.WriteFile(binaryPath, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config.GlobalConfig.Go2RTC.StartTimeout
+	config.GlobalConfig.Go2RTC.StartTimeout = 2
+	t.Cleanup(func() { config.GlobalConfig.Go2RTC.StartTimeout = previous })
+
+	return &Manager{
+		configPath: filepath.Join(dir, "go2rtc.yaml"),
+		binaryPath: binaryPath,
+	}
+}
+
+// waitFor ждет выполнения условия
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWaitReadyAfterDelay(t *testing.T) {
+	var requests atomic.Int32
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Первые запросы - API еще не поднялся
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+
+	if err := waitReady(make(chan struct{}), 5*time.Second); err != nil {
+		t.Fatalf("waitReady: %v", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("запросов к API: %d, ожидалось 3", n)
+	}
+}
+
+func TestWaitReadyTimeout(t *testing.T) {
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	started := time.Now()
+	err := waitReady(make(chan struct{}), 500*time.Millisecond)
+	if err == nil {
+		t.Fatal("ожидалась ошибка по таймауту")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("waitReady ждал %v", elapsed)
+	}
+}
+
+func TestWaitReadyProcessExited(t *testing.T) {
+	server := newFakeGo2RTC(t, http.NotFoundHandler())
+	server.Close()
+
+	exited := make(chan struct{})
+	close(exited)
+	err := waitReady(exited, 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "завершился") {
+		t.Fatalf("ожидалась ошибка завершения процесса, получено %v", err)
+	}
+}
+
+func TestStartFailsWhenProcessExits(t *testing.T) {
+	server := newFakeGo2RTC(t, http.NotFoundHandler())
+	server.Close()
+	m := newFakeBinary(t, "exit 1")
+
+	if err := m.Start(); err == nil {
+		t.Fatal("ожидалась ошибка запуска")
+	}
+	if m.IsRunning() {
+		t.Fatal("менеджер считает go2rtc запущенным")
+	}
+}
+
+func TestSuperviseRestartsAfterCrash(t *testing.T) {
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	m := newFakeBinary(t, "exec sleep 30")
+
+	var mu sync.Mutex
+	var statuses []bool
+	m.SetStatusHandler(func(running bool, reason string) {
+		mu.Lock()
+		statuses = append(statuses, running)
+		mu.Unlock()
+	})
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { m.Stop() })
+
+	m.mu.Lock()
+	first := m.process
+	m.mu.Unlock()
+	first.Process.Kill()
+
+	waitFor(t, 5*time.Second, "перезапуск go2rtc", func() bool {
+		return m.Status().Restarts == 1 && m.IsRunning()
+	})
+
+	status := m.Status()
+	if status.PID == 0 || status.PID == first.Process.Pid {
+		t.Fatalf("PID после перезапуска %d, до %d", status.PID, first.Process.Pid)
+	}
+	if status.LastExitReason == "" || status.LastExitAt == nil {
+		t.Fatalf("нет причины завершения: %+v", status)
+	}
+
+	mu.Lock()
+	got := append([]bool(nil), statuses...)
+	mu.Unlock()
+	if len(got) != 2 || got[0] || !got[1] {
+		t.Fatalf("уведомления о состоянии %v, ожидалось [false true]", got)
+	}
+}
+
+func TestShutdownStopsSupervisor(t *testing.T) {
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	m := newFakeBinary(t, "exec sleep 30")
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if m.IsRunning() {
+		t.Fatal("go2rtc работает после остановки")
+	}
+
+	// После остановки супервизор не должен перезапускать процесс
+	time.Sleep(minRestartDelay + 200*time.Millisecond)
+	if m.IsRunning() || m.Status().Restarts != 0 {
+		t.Fatalf("go2rtc перезапущен после остановки: %+v", m.Status())
+	}
+}
+
+func TestShutdownKillsAfterGracePeriod(t *testing.T) {
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// Процесс игнорирует SIGTERM, остановить его можно только SIGKILL
+	m := newFakeBinary(t, "trap '' TERM\ntouch \"$0.trapped\"\nwhile true; do sleep 1; done")
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// API отвечает раньше, чем скрипт успевает установить обработчик сигнала
+	waitFor(t, 5*time.Second, "обработчик SIGTERM", func() bool {
+		_, err := os.Stat(m.binaryPath + ".trapped")
+		return err == nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 300*time.Millisecond || elapsed > 3*time.Second {
+		t.Fatalf("Shutdown занял %v", elapsed)
+	}
+	if m.IsRunning() {
+		t.Fatal("go2rtc работает после остановки")
+	}
+}
+
+func TestShutdownAfterProcessExited(t *testing.T) {
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	m := newFakeBinary(t, "exec sleep 30")
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// Процесс завершился, а супервизор еще не успел это заметить
+	m.mu.Lock()
+	process := m.process
+	m.mu.Unlock()
+	process.Process.Kill()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestCreateConfigListensOnLoopback(t *testing.T) {
+	m := &Manager{configPath: filepath.Join(t.TempDir(), "go2rtc.yaml")}
+	if err := m.createConfig(); err != nil {
+		t.Fatalf("createConfig: %v", err)
+	}
+
+	data, err := os.ReadFile(m.configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, "listen:") && !strings.Contains(line, "listen: 127.0.0.1:") {
+			t.Errorf("go2rtc слушает не только локальный адрес: %q", strings.TrimSpace(line))
+		}
+	}
+}
+
+func TestSuperviseRestoresStreamsAfterCrash(t *testing.T) {
+	fake := &fakeStreams{sources: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Handle("/api/streams", fake)
+	newFakeGo2RTC(t, mux)
+
+	previous := config.GlobalConfig.Channels
+	config.GlobalConfig.Channels = []config.Channel{{ID: "101", URL: "rtsp://cam/101"}}
+	t.Cleanup(func() { config.GlobalConfig.Channels = previous })
+
+	m := newFakeBinary(t, "exec sleep 30")
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { m.Stop() })
+
+	name := PlaybackStreamPrefix + "restore"
+	if err := RegisterPlaybackStream(name, "rtsp://nvr/playback", "admin"); err != nil {
+		t.Fatalf("RegisterPlaybackStream: %v", err)
+	}
+	t.Cleanup(func() { ReleasePlaybackStream(name) })
+
+	// Новый процесс go2rtc не знает о потоках, добавленных через API
+	fake.mu.Lock()
+	fake.sources = make(map[string]string)
+	fake.mu.Unlock()
+
+	m.mu.Lock()
+	first := m.process
+	m.mu.Unlock()
+	first.Process.Kill()
+
+	waitFor(t, 5*time.Second, "восстановление потоков", func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.sources["101"] == "rtsp://cam/101" && fake.sources[name] == "rtsp://nvr/playback"
+	})
+
+	if owner, err := PlaybackStreamOwner(name); err != nil || owner != "admin" {
+		t.Fatalf("владелец временного потока %q, %v", owner, err)
+	}
+}
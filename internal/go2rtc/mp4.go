@@ -0,0 +1,54 @@
+// internal/go2rtc/mp4.go
+package go2rtc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// streamClient - клиент без общего таймаута для долгих загрузок;
+// время ограничивается контекстом запроса
+var streamClient = &http.Client{}
+
+// DownloadMP4 записывает в w поток src в формате MP4 длительностью duration.
+// go2rtc воспроизводит источник в реальном времени, поэтому загрузка
+// занимает не меньше duration.
+func DownloadMP4(ctx context.Context, src string, duration time.Duration, w io.Writer) (int64, error) {
+	seconds := int(duration.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	endpoint := fmt.Sprintf("%s/api/stream.mp4?src=%s&duration=%s",
+		APIBaseURL(), url.QueryEscape(src), strconv.Itoa(seconds))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+	}
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return 0, context.Canceled
+		}
+		return 0, wrapRequestError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return 0, checkStatus(resp.StatusCode, body)
+	}
+
+	written, err := io.Copy(w, resp.Body)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return written, context.Canceled
+	}
+	return written, err
+}
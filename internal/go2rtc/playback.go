@@ -0,0 +1,199 @@
+// internal/go2rtc/playback.go
+package go2rtc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PlaybackStreamPrefix - префикс имен временных потоков архива
+	PlaybackStreamPrefix = "playback_"
+	// playbackIdleTimeout - сколько временный поток живет без зрителей
+	playbackIdleTimeout = 60 * time.Second
+	// playbackCheckInterval - период проверки временных потоков
+	playbackCheckInterval = 15 * time.Second
+)
+
+// tempStream - временный поток go2rtc для воспроизведения архива
+type tempStream struct {
+	src        string // источник потока для восстановления после перезапуска go2rtc
+	owner      string // пользователь, запустивший воспроизведение
+	lastActive time.Time
+}
+
+var (
+	tempStreams     = make(map[string]*tempStream)
+	tempStreamsMu   sync.Mutex
+	tempJanitorOnce sync.Once
+)
+
+// RegisterPlaybackStream добавляет временный поток архива в go2rtc.
+// Поток удаляется вызовом ReleasePlaybackStream или автоматически,
+// если у него нет зрителей дольше playbackIdleTimeout. owner - имя
+// пользователя (пусто без авторизации), только он может остановить поток.
+func RegisterPlaybackStream(name, src, owner string) error {
+	if err := AddStream(name, src); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tempStreamsMu.Lock()
+	tempStreams[name] = &tempStream{src: src, owner: owner, lastActive: now}
+	tempStreamsMu.Unlock()
+
+	tempJanitorOnce.Do(func() {
+		go runPlaybackJanitor()
+	})
+
+	log.Printf("📼 Временный поток %s зарегистрирован в go2rtc", name)
+	return nil
+}
+
+// UpdatePlaybackStream заменяет источник временного потока архива без его
+// удаления: при ошибке поток остается на прежнем источнике. Если поток еще
+// не зарегистрирован или go2rtc его уже не знает, он регистрируется заново.
+func UpdatePlaybackStream(name, src, owner string) error {
+	tempStreamsMu.Lock()
+	_, ok := tempStreams[name]
+	tempStreamsMu.Unlock()
+	if !ok {
+		return RegisterPlaybackStream(name, src, owner)
+	}
+
+	if err := PatchStream(name, src); err != nil {
+		if errors.Is(err, ErrStreamNotFound) {
+			return RegisterPlaybackStream(name, src, owner)
+		}
+		return err
+	}
+
+	tempStreamsMu.Lock()
+	if stream, ok := tempStreams[name]; ok {
+		stream.src = src
+		stream.lastActive = time.Now()
+	}
+	tempStreamsMu.Unlock()
+
+	log.Printf("📼 Источник временного потока %s обновлен", name)
+	return nil
+}
+
+// ReleasePlaybackStream удаляет временный поток архива. Если go2rtc не
+// удалил поток, он остается на учете, и его удалит повторный вызов или
+// очистка простаивающих потоков.
+func ReleasePlaybackStream(name string) error {
+	tempStreamsMu.Lock()
+	_, ok := tempStreams[name]
+	tempStreamsMu.Unlock()
+
+	if !ok {
+		return ErrStreamNotFound
+	}
+
+	err := DeleteStream(name)
+	if err != nil && !errors.Is(err, ErrStreamNotFound) {
+		return err
+	}
+
+	tempStreamsMu.Lock()
+	delete(tempStreams, name)
+	tempStreamsMu.Unlock()
+
+	log.Printf("🗑️ Временный поток %s удален из go2rtc", name)
+	return nil
+}
+
+// PlaybackStreamOwner возвращает владельца временного потока архива
+func PlaybackStreamOwner(name string) (string, error) {
+	tempStreamsMu.Lock()
+	defer tempStreamsMu.Unlock()
+
+	stream, ok := tempStreams[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrStreamNotFound, name)
+	}
+	return stream.owner, nil
+}
+
+// IsPlaybackStream проверяет, является ли поток временным потоком архива
+func IsPlaybackStream(name string) bool {
+	if strings.HasPrefix(name, PlaybackStreamPrefix) {
+		return true
+	}
+
+	tempStreamsMu.Lock()
+	defer tempStreamsMu.Unlock()
+	_, ok := tempStreams[name]
+	return ok
+}
+
+// restorePlaybackStreams заново добавляет временные потоки архива
+// в перезапущенный go2rtc
+func restorePlaybackStreams() {
+	tempStreamsMu.Lock()
+	sources := make(map[string]string, len(tempStreams))
+	for name, stream := range tempStreams {
+		sources[name] = stream.src
+	}
+	tempStreamsMu.Unlock()
+
+	for name, src := range sources {
+		if err := AddStream(name, src); err != nil {
+			log.Printf("⚠️ Ошибка восстановления временного потока %s: %v", name, err)
+			continue
+		}
+		log.Printf("📼 Временный поток %s восстановлен в go2rtc", name)
+	}
+}
+
+// runPlaybackJanitor периодически удаляет временные потоки без зрителей
+func runPlaybackJanitor() {
+	ticker := time.NewTicker(playbackCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cleanupPlaybackStreams()
+	}
+}
+
+// cleanupPlaybackStreams удаляет простаивающие временные потоки
+func cleanupPlaybackStreams() {
+	tempStreamsMu.Lock()
+	names := make([]string, 0, len(tempStreams))
+	for name := range tempStreams {
+		names = append(names, name)
+	}
+	tempStreamsMu.Unlock()
+
+	now := time.Now()
+	for _, name := range names {
+		info, err := GetStreamInfo(name)
+		if errors.Is(err, ErrStreamNotFound) {
+			// go2rtc уже не знает о потоке (например, после перезапуска)
+			tempStreamsMu.Lock()
+			delete(tempStreams, name)
+			tempStreamsMu.Unlock()
+			continue
+		}
+
+		tempStreamsMu.Lock()
+		stream, ok := tempStreams[name]
+		if ok && err == nil && len(info.Consumers) > 0 {
+			stream.lastActive = now
+		}
+		idle := ok && now.Sub(stream.lastActive) > playbackIdleTimeout
+		tempStreamsMu.Unlock()
+
+		if idle {
+			log.Printf("⏱️ Временный поток %s простаивает, удаление", name)
+			if err := ReleasePlaybackStream(name); err != nil && !errors.Is(err, ErrStreamNotFound) {
+				log.Printf("⚠️ Ошибка удаления временного потока %s: %v", name, err)
+			}
+		}
+	}
+}
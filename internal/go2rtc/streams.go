@@ -0,0 +1,130 @@
+// internal/go2rtc/streams.go
+package go2rtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// StreamInfo - состояние потока в go2rtc
+type StreamInfo struct {
+	Producers []json.RawMessage `json:"producers"`
+	Consumers []json.RawMessage `json:"consumers"`
+}
+
+// Source возвращает URL первого источника потока
+func (s *StreamInfo) Source() string {
+	for _, raw := range s.Producers {
+		var producer struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(raw, &producer); err == nil && producer.URL != "" {
+			return producer.URL
+		}
+	}
+	return ""
+}
+
+// ListStreams возвращает все потоки, зарегистрированные в go2rtc
+func ListStreams() (map[string]*StreamInfo, error) {
+	body, err := doStreamsRequest("GET", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	streams := make(map[string]*StreamInfo)
+	if err := json.Unmarshal(body, &streams); err != nil {
+		return nil, fmt.Errorf("ошибка разбора списка потоков go2rtc: %v", err)
+	}
+
+	return streams, nil
+}
+
+// AddStream регистрирует поток в go2rtc через API
+func AddStream(name, src string) error {
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("src", src)
+
+	_, err := doStreamsRequest("PUT", query)
+	if err != nil {
+		return fmt.Errorf("ошибка добавления потока %s: %w", name, err)
+	}
+	return nil
+}
+
+// PatchStream заменяет источник существующего потока
+func PatchStream(name, src string) error {
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("src", src)
+
+	_, err := doStreamsRequest("PATCH", query)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления потока %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteStream удаляет поток из go2rtc
+func DeleteStream(name string) error {
+	query := url.Values{}
+	query.Set("src", name)
+
+	_, err := doStreamsRequest("DELETE", query)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления потока %s: %w", name, err)
+	}
+	return nil
+}
+
+// GetStreamInfo возвращает состояние потока или ErrStreamNotFound
+func GetStreamInfo(name string) (*StreamInfo, error) {
+	query := url.Values{}
+	query.Set("src", name)
+
+	body, err := doStreamsRequest("GET", query)
+	if err != nil {
+		return nil, err
+	}
+
+	// go2rtc возвращает null для неизвестного потока
+	var info *StreamInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("ошибка разбора ответа go2rtc: %v", err)
+	}
+	if info == nil {
+		return nil, fmt.Errorf("%w: %s", ErrStreamNotFound, name)
+	}
+
+	return info, nil
+}
+
+// doStreamsRequest выполняет запрос к /api/streams
+func doStreamsRequest(method string, query url.Values) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s/api/streams?%s", APIBaseURL(), query.Encode())
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+	}
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, wrapRequestError(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа go2rtc: %v", err)
+	}
+
+	if err := checkStatus(resp.StatusCode, body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
@@ -0,0 +1,185 @@
+// internal/go2rtc/streams_test.go
+package go2rtc
+
+import (
+	"TeleOko/internal/config"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeStreams - список потоков API go2rtc /api/streams в памяти
+type fakeStreams struct {
+	mu      sync.Mutex
+	sources map[string]string
+}
+
+func (f *fakeStreams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/streams" {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		streams := make(map[string]any)
+		for name, src := range f.sources {
+			streams[name] = map[string]any{
+				"producers": []map[string]string{{"url": src}},
+				"consumers": []any{},
+			}
+		}
+		if src := query.Get("src"); src != "" {
+			json.NewEncoder(w).Encode(streams[src])
+			return
+		}
+		json.NewEncoder(w).Encode(streams)
+	case http.MethodPut:
+		f.sources[query.Get("name")] = query.Get("src")
+	case http.MethodPatch:
+		if _, ok := f.sources[query.Get("name")]; !ok {
+			http.Error(w, "stream not found", http.StatusNotFound)
+			return
+		}
+		f.sources[query.Get("name")] = query.Get("src")
+	case http.MethodDelete:
+		delete(f.sources, query.Get("src"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestUpdateStreams(t *testing.T) {
+	fake := &fakeStreams{sources: map[string]string{
+		"101":                      "rtsp://cam/101",
+		"102":                      "rtsp://cam/old",
+		"999":                      "rtsp://cam/999",
+		PlaybackStreamPrefix + "x": "rtsp://nvr/playback",
+	}}
+	newFakeGo2RTC(t, fake)
+
+	previous := config.GlobalConfig.Channels
+	config.GlobalConfig.Channels = []config.Channel{
+		{ID: "101", URL: "rtsp://cam/101"},
+		{ID: "102", URL: "rtsp://cam/102"},
+		{ID: "103", URL: "rtsp://cam/103"},
+	}
+	t.Cleanup(func() { config.GlobalConfig.Channels = previous })
+
+	m := &Manager{isRunning: true}
+	changes, err := m.UpdateStreams()
+	if err != nil {
+		t.Fatalf("UpdateStreams: %v", err)
+	}
+
+	actions := make(map[string]string)
+	for _, change := range changes {
+		if change.Error != "" {
+			t.Errorf("поток %s: %s", change.Name, change.Error)
+		}
+		actions[change.Name] = change.Action
+	}
+	want := map[string]string{
+		"101": StreamUnchanged,
+		"102": StreamUpdated,
+		"103": StreamAdded,
+		"999": StreamRemoved,
+	}
+	for name, action := range want {
+		if actions[name] != action {
+			t.Errorf("поток %s: действие %q, ожидалось %q", name, actions[name], action)
+		}
+	}
+	if len(actions) != len(want) {
+		t.Errorf("лишние изменения: %v", actions)
+	}
+
+	names := make([]string, 0, len(fake.sources))
+	for name := range fake.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	wantNames := []string{"101", "102", "103", PlaybackStreamPrefix + "x"}
+	if len(names) != len(wantNames) {
+		t.Fatalf("потоки после синхронизации %v, ожидались %v", names, wantNames)
+	}
+	for i := range names {
+		if names[i] != wantNames[i] {
+			t.Fatalf("потоки после синхронизации %v, ожидались %v", names, wantNames)
+		}
+	}
+	if fake.sources["102"] != "rtsp://cam/102" {
+		t.Errorf("источник 102 = %q", fake.sources["102"])
+	}
+}
+
+func TestUpdateStreamsNotRunning(t *testing.T) {
+	m := &Manager{}
+	if _, err := m.UpdateStreams(); err == nil {
+		t.Fatal("ожидалась ошибка для остановленного go2rtc")
+	}
+}
+
+func TestUpdateStreamsUnavailable(t *testing.T) {
+	server := newFakeGo2RTC(t, http.NotFoundHandler())
+	server.Close()
+
+	m := &Manager{isRunning: true}
+	if _, err := m.UpdateStreams(); err == nil {
+		t.Fatal("ожидалась ошибка при недоступном go2rtc")
+	}
+}
+
+func TestReleasePlaybackStreamKeepsStreamOnError(t *testing.T) {
+	fake := &fakeStreams{sources: make(map[string]string)}
+	var failDelete atomic.Bool
+	newFakeGo2RTC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			if failDelete.Load() {
+				http.Error(w, "busy", http.StatusInternalServerError)
+				return
+			}
+			fake.mu.Lock()
+			_, ok := fake.sources[r.URL.Query().Get("src")]
+			fake.mu.Unlock()
+			if !ok {
+				http.Error(w, "stream not found", http.StatusNotFound)
+				return
+			}
+		}
+		fake.ServeHTTP(w, r)
+	}))
+
+	name := PlaybackStreamPrefix + "release"
+	if err := RegisterPlaybackStream(name, "rtsp://nvr/playback", "admin"); err != nil {
+		t.Fatalf("RegisterPlaybackStream: %v", err)
+	}
+
+	failDelete.Store(true)
+	if err := ReleasePlaybackStream(name); err == nil {
+		t.Fatal("ожидалась ошибка удаления")
+	}
+	if owner, err := PlaybackStreamOwner(name); err != nil || owner != "admin" {
+		t.Fatalf("поток снят с учета после ошибки: %q, %v", owner, err)
+	}
+
+	// go2rtc уже не знает о потоке: учет очищается без ошибки
+	failDelete.Store(false)
+	fake.mu.Lock()
+	delete(fake.sources, name)
+	fake.mu.Unlock()
+	if err := ReleasePlaybackStream(name); err != nil {
+		t.Fatalf("ReleasePlaybackStream: %v", err)
+	}
+	if _, err := PlaybackStreamOwner(name); !errors.Is(err, ErrStreamNotFound) {
+		t.Fatalf("поток остался на учете: %v", err)
+	}
+}
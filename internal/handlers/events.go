@@ -0,0 +1,261 @@
+// internal/handlers/events.go
+package handlers
+
+import (
+	"TeleOko/internal/auth"
+	"TeleOko/internal/eventlog"
+	"TeleOko/internal/events"
+	"TeleOko/internal/hikvision"
+	"TeleOko/internal/timeutil"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventsHeartbeat - период комментариев SSE, чтобы прокси не закрывали соединение
+const eventsHeartbeat = 30 * time.Second
+
+// Параметры журнала событий
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+	// Запас записи до и после события для просмотра архива
+	eventPlaybackBefore = 15 * time.Second
+	eventPlaybackAfter  = 30 * time.Second
+)
+
+// historyEvent - событие журнала в ответе API
+type historyEvent struct {
+	ID            string `json:"id"`
+	Device        string `json:"device"`
+	Channel       string `json:"channel,omitempty"`
+	DeviceChannel string `json:"device_channel,omitempty"`
+	Type          string `json:"type"`
+	RawType       string `json:"raw_type"`
+	Description   string `json:"description,omitempty"`
+	Start         string `json:"start"`
+	End           string `json:"end"`
+	Active        bool   `json:"active"`
+	SnapshotURL   string `json:"snapshot_url,omitempty"`
+	PlaybackURL   string `json:"playback_url,omitempty"`
+	PlaybackStart string `json:"playback_start,omitempty"`
+	PlaybackEnd   string `json:"playback_end,omitempty"`
+}
+
+// StreamEvents передает события устройств браузеру через Server-Sent Events.
+// Параметры channel и type (через запятую) ограничивают поток событий.
+func StreamEvents(c *gin.Context) {
+	channels := splitFilter(c.Query("channel"))
+	types := splitFilter(strings.ToLower(c.Query("type")))
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Потоковая передача не поддерживается"})
+		return
+	}
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, "retry: 5000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			flusher.Flush()
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !eventVisible(c, event) || !matchFilter(channels, event.Channel) || !matchFilter(types, event.Type) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("❌ Ошибка сериализации события: %v", err)
+				continue
+			}
+			fmt.Fprintf(c.Writer, "id: %s\ndata: %s\n\n", event.ID, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// GetEventHistory возвращает события из журнала от новых к старым.
+// Фильтры: channel и type (через запятую), start и end (время начала
+// события), постраничный вывод через limit и cursor.
+func GetEventHistory(c *gin.Context) {
+	filter := eventlog.Filter{
+		Channels: splitFilter(c.Query("channel")),
+		Types:    splitFilter(strings.ToLower(c.Query("type"))),
+		Allow: func(r eventlog.Record) bool {
+			return recordVisible(c, r)
+		},
+	}
+
+	var err error
+	if start := c.Query("start"); start != "" {
+		if filter.Start, filter.End, err = timeutil.ParseRange(start, c.Query("end"), time.Local); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else if end := c.Query("end"); end != "" {
+		if filter.End, err = timeutil.Parse(end, time.Local); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	limit := defaultHistoryLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxHistoryLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Параметр limit должен быть числом от 1 до %d", maxHistoryLimit),
+			})
+			return
+		}
+	}
+
+	records, nextCursor, err := eventlog.Query(filter, c.Query("cursor"), limit)
+	if err != nil {
+		log.Printf("❌ Ошибка чтения журнала событий: %v", err)
+		c.JSON(eventLogErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]historyEvent, 0, len(records))
+	for _, r := range records {
+		items = append(items, newHistoryEvent(r))
+	}
+
+	response := gin.H{
+		"events": items,
+		"count":  len(items),
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetEventSnapshot отдает снимок, сохраненный в начале события
+func GetEventSnapshot(c *gin.Context) {
+	record, err := eventlog.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(eventLogErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !recordVisible(c, record) {
+		denyChannel(c, record.Channel)
+		return
+	}
+
+	path := eventlog.SnapshotPath(record)
+	if path == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Снимок события не сохранен"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.File(path)
+}
+
+// newHistoryEvent формирует событие журнала для ответа со ссылками
+// на снимок и на создание сессии воспроизведения архива вокруг события
+func newHistoryEvent(r eventlog.Record) historyEvent {
+	item := historyEvent{
+		ID:            r.ID,
+		Device:        r.Device,
+		Channel:       r.Channel,
+		DeviceChannel: r.DeviceChannel,
+		Type:          r.Type,
+		RawType:       r.RawType,
+		Description:   r.Description,
+		Start:         r.Start.Format(time.RFC3339),
+		End:           r.End.Format(time.RFC3339),
+		Active:        r.Active,
+	}
+	if r.Snapshot != "" {
+		item.SnapshotURL = "/api/events/history/" + url.PathEscape(r.ID) + "/snapshot"
+	}
+
+	if r.Channel != "" {
+		start := r.Start.Add(-eventPlaybackBefore).Format(time.RFC3339)
+		end := r.End.Add(eventPlaybackAfter).Format(time.RFC3339)
+		query := url.Values{"channel": {r.Channel}, "start": {start}, "end": {end}}
+		item.PlaybackURL = "/api/playback/sessions?" + query.Encode()
+		item.PlaybackStart = start
+		item.PlaybackEnd = end
+	}
+	return item
+}
+
+// recordVisible проверяет доступ пользователя к записи журнала
+func recordVisible(c *gin.Context, r eventlog.Record) bool {
+	return eventVisible(c, hikvision.Event{Channel: r.Channel})
+}
+
+// eventLogErrorStatus подбирает HTTP статус для ошибки журнала событий
+func eventLogErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, eventlog.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, eventlog.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, eventlog.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// eventVisible проверяет доступ пользователя к событию. События без канала
+// (диск, сеть устройства) видит только администратор.
+func eventVisible(c *gin.Context, event hikvision.Event) bool {
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		return true
+	}
+	if event.Channel == "" {
+		return user.Role.HasPermission(auth.PermAdmin)
+	}
+	return user.CanAccessChannel(event.Channel)
+}
+
+// splitFilter разбирает список значений через запятую
+func splitFilter(value string) map[string]bool {
+	filter := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			filter[item] = true
+		}
+	}
+	return filter
+}
+
+// matchFilter проверяет значение по фильтру (пустой фильтр пропускает все)
+func matchFilter(filter map[string]bool, value string) bool {
+	return len(filter) == 0 || filter[value]
+}
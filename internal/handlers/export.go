@@ -0,0 +1,98 @@
+// internal/handlers/export.go
+package handlers
+
+import (
+	"TeleOko/internal/config"
+	"TeleOko/internal/export"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportClip отдает архивную запись канала за интервал файлом MP4.
+// Пока клип выгружается, он передается по мере получения; запросы Range
+// поддерживаются для уже выгруженного клипа. При отключении клиента
+// выгрузка отменяется.
+func ExportClip(c *gin.Context) {
+	channelID := c.Query("channel")
+	start := c.Query("start")
+	end := c.Query("end")
+
+	if channelID == "" || start == "" || end == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Не указаны обязательные параметры (channel, start, end)",
+		})
+		return
+	}
+
+	if !channelAllowed(c, channelID) {
+		denyChannel(c, channelID)
+		return
+	}
+
+	startTime, endTime, ok := parseTimeRange(c, start, end)
+	if !ok {
+		return
+	}
+
+	maxDuration := config.GetExportMaxDuration()
+	if endTime.Sub(startTime) > maxDuration {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Длительность клипа превышает максимальную (%s)", maxDuration),
+		})
+		return
+	}
+
+	clip, err := export.Get(c.Request.Context(), channelID, startTime, endTime)
+	if errors.Is(err, context.Canceled) {
+		log.Printf("⏹️ Выгрузка канала %s отменена: клиент отключился", channelID)
+		return
+	}
+	if errors.Is(err, export.ErrEmptyClip) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(hikvisionErrorStatus(err), gin.H{
+			"error": fmt.Sprintf("Ошибка выгрузки записи: %v", err),
+		})
+		return
+	}
+
+	defer clip.Close()
+
+	if !clip.Complete() {
+		// Клип еще выгружается: отдаем данные по мере получения, без Range
+		setClipHeaders(c, clip)
+		c.Header("Accept-Ranges", "none")
+		c.Status(http.StatusOK)
+		if _, err := clip.Stream(c.Request.Context(), c.Writer); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("❌ Ошибка передачи клипа канала %s: %v", channelID, err)
+		}
+		return
+	}
+
+	file, err := os.Open(clip.Path)
+	if err != nil {
+		log.Printf("❌ Ошибка открытия клипа %s: %v", clip.Path, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Клип недоступен, повторите запрос"})
+		return
+	}
+	defer file.Close()
+
+	setClipHeaders(c, clip)
+	http.ServeContent(c.Writer, c.Request, clip.Name, clip.ModTime, file)
+}
+
+// setClipHeaders задает заголовки ответа с файлом клипа
+func setClipHeaders(c *gin.Context, clip *export.Clip) {
+	c.Header("Content-Type", "video/mp4")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": clip.Name}))
+	c.Header("Cache-Control", "private")
+}
@@ -2,9 +2,15 @@
 package handlers
 
 import (
+	"TeleOko/internal/auth"
 	"TeleOko/internal/config"
+	"TeleOko/internal/go2rtc"
 	"TeleOko/internal/hikvision"
 	"TeleOko/internal/network"
+	"TeleOko/internal/redact"
+	"TeleOko/internal/timeutil"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -23,7 +29,7 @@ func GetSystemInfo(c *gin.Context) {
 	channels := config.GetChannels()
 	localIP, _ := network.GetLocalIP()
 
-	c.JSON(http.StatusOK, gin.H{
+	info := gin.H{
 		"status":         "online",
 		"version":        "2.0.0",
 		"channels_count": len(channels),
@@ -31,12 +37,19 @@ func GetSystemInfo(c *gin.Context) {
 		"go2rtc_port":    config.GetGo2RTCPort(),
 		"local_ip":       localIP,
 		"timestamp":      time.Now().Unix(),
-	})
+	}
+
+	// Состояние процесса go2rtc: перезапуски и причина последнего падения
+	if config.IsGo2RTCEnabled() {
+		info["go2rtc"] = go2rtc.NewManager().Status()
+	}
+
+	c.JSON(http.StatusOK, info)
 }
 
 // GetChannels возвращает список доступных каналов
 func GetChannels(c *gin.Context) {
-	channels := config.GetChannels()
+	channels := VisibleChannels(c)
 
 	// Логируем все доступные каналы с их RTSP URL
 	log.Printf("📺 Запрос списка каналов - всего доступно: %d каналов", len(channels))
@@ -44,6 +57,16 @@ func GetChannels(c *gin.Context) {
 		log.Printf("  📹 [%s] %s -> %s", channel.ID, channel.Name, channel.URL)
 	}
 
+	// Пароли в URL каналов видны только администратору по запросу ?raw=true
+	if !rawURLsRequested(c) {
+		masked := make([]config.Channel, len(channels))
+		for i, channel := range channels {
+			channel.URL = redact.URL(channel.URL)
+			masked[i] = channel
+		}
+		channels = masked
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"channels": channels,
 		"count":    len(channels),
@@ -59,6 +82,11 @@ func GetLiveStream(c *gin.Context) {
 		return
 	}
 
+	if !channelAllowed(c, channelID) {
+		denyChannel(c, channelID)
+		return
+	}
+
 	// Проверяем, существует ли канал
 	channel := config.GetChannelByID(channelID)
 	if channel == nil {
@@ -73,18 +101,14 @@ func GetLiveStream(c *gin.Context) {
 	log.Printf("  🌐 RTSP URL: %s", channel.URL)
 	log.Printf("  🎥 go2rtc включен: %t", config.IsGo2RTCEnabled())
 
-	// Если go2rtc включен, возвращаем WebRTC URL
+	// Если go2rtc включен, плеер подключается через /api/webrtc/offer:
+	// сам go2rtc доступен только локально и не проверяет права
 	if config.IsGo2RTCEnabled() {
-		go2rtcURL := fmt.Sprintf("http://localhost:%d/api/ws?src=%s",
-			config.GetGo2RTCPort(), channelID)
-
-		log.Printf("  ✅ WebRTC URL: %s", go2rtcURL)
-
 		c.JSON(http.StatusOK, gin.H{
 			"channel":      channelID,
 			"channel_name": channel.Name,
-			"webrtc_url":   go2rtcURL,
-			"rtsp_url":     channel.URL,
+			"offer_url":    "/api/webrtc/offer?channel=" + url.QueryEscape(channelID),
+			"rtsp_url":     exposeURL(c, channel.URL),
 			"type":         "webrtc",
 		})
 	} else {
@@ -94,7 +118,7 @@ func GetLiveStream(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"channel":      channelID,
 			"channel_name": channel.Name,
-			"rtsp_url":     channel.URL,
+			"rtsp_url":     exposeURL(c, channel.URL),
 			"type":         "rtsp",
 		})
 	}
@@ -109,6 +133,11 @@ func HandleWebRTCOffer(c *gin.Context) {
 		return
 	}
 
+	if !channelAllowed(c, channelID) {
+		denyChannel(c, channelID)
+		return
+	}
+
 	// Получаем информацию о канале для логирования
 	channel := config.GetChannelByID(channelID)
 	rtspURL := "неизвестен"
@@ -129,40 +158,32 @@ func HandleWebRTCOffer(c *gin.Context) {
 	}
 
 	// Читаем SDP предложение из тела запроса
-	var offerData map[string]interface{}
-	if err := c.ShouldBindJSON(&offerData); err != nil {
+	var offer go2rtc.SessionDescription
+	if err := c.ShouldBindJSON(&offer); err != nil || offer.SDP == "" {
 		log.Printf("  ❌ Ошибка чтения SDP offer: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
 		return
 	}
 
-	// Пытаемся проксировать запрос к go2rtc
-	go2rtcURL := fmt.Sprintf("http://localhost:%d/api/webrtc", config.GetGo2RTCPort())
-	log.Printf("  🔄 Проксирование к go2rtc: %s", go2rtcURL)
+	// Передаем предложение в go2rtc
+	log.Printf("  🔄 Обмен SDP через go2rtc: %s/api/webrtc?src=%s", go2rtc.APIBaseURL(), channelID)
 
-	// Формируем запрос к go2rtc
-	requestBody := map[string]interface{}{
-		"type":  "webrtc",
-		"value": offerData,
-		"src":   channelID,
+	answer, err := go2rtc.ExchangeSDP(channelID, offer)
+	if err != nil {
+		log.Printf("  ❌ Ошибка обмена SDP: %v", err)
+		c.JSON(go2rtcErrorStatus(err), gin.H{
+			"error": fmt.Sprintf("Ошибка WebRTC: %v", err),
+		})
+		return
 	}
 
-	// Отправляем запрос к go2rtc (упрощенная версия)
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	// Пока возвращаем базовый SDP ответ
-	// В полной реализации здесь должен быть HTTP POST к go2rtc
 	log.Printf("  ✅ WebRTC соединение для канала %s (RTSP: %s)", channelID, rtspURL)
-	_ = client      // используем переменную
-	_ = requestBody // используем переменную
 
-	c.JSON(http.StatusOK, gin.H{
-		"type": "answer",
-		"sdp":  generateWebRTCSDP(channelID),
-	})
+	c.JSON(http.StatusOK, answer)
 }
 
-// GetRecordings получает список архивных записей
+// GetRecordings получает список архивных записей.
+// Параметр channel принимает один канал, список через запятую или all.
 func GetRecordings(c *gin.Context) {
 	channelID := c.Query("channel")
 	startDate := c.Query("start")
@@ -176,9 +197,20 @@ func GetRecordings(c *gin.Context) {
 		return
 	}
 
-	// Если конечная дата не указана, используем начальную
-	if endDate == "" {
-		endDate = startDate
+	// Если конечная дата не указана, ищем до конца дня начала
+	startTime, endTime, ok := parseTimeRange(c, startDate, endDate)
+	if !ok {
+		return
+	}
+
+	if channelID == allChannels || strings.Contains(channelID, ",") {
+		getMultiChannelRecordings(c, channelID, startTime, endTime)
+		return
+	}
+
+	if !channelAllowed(c, channelID) {
+		denyChannel(c, channelID)
+		return
 	}
 
 	// Получаем информацию о канале для логирования
@@ -191,14 +223,52 @@ func GetRecordings(c *gin.Context) {
 	}
 
 	log.Printf("📼 ПОИСК АРХИВА - Канал %s (%s)", channelID, channelName)
-	log.Printf("  📅 Период: %s - %s", startDate, endDate)
+	log.Printf("  📅 Период: %s - %s", startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
 	log.Printf("  🌐 RTSP источник: %s", rtspURL)
 
+	// Фильтр по типу события (motion, linedetection, fielddetection, alarm)
+	event, err := hikvision.ParseEvent(c.Query("event"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if event != "" {
+		log.Printf("  🎯 Событие: %s", event)
+	}
+
+	// Постраничный режим включается параметрами limit или cursor
+	cursor := c.Query("cursor")
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > hikvision.MaxSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Параметр limit должен быть числом от 1 до %d", hikvision.MaxSearchLimit),
+			})
+			return
+		}
+	}
+	paged := cursor != "" || limit > 0
+
 	// Поиск записей через Hikvision API
-	recordings, err := hikvision.SearchRecordings(channelID, startDate, endDate)
+	var recordings []hikvision.Recording
+	var nextCursor string
+	if paged {
+		recordings, nextCursor, err = hikvision.SearchRecordingsPage(c.Request.Context(), channelID, startTime, endTime, event, cursor, limit)
+	} else {
+		recordings, err = hikvision.SearchRecordings(c.Request.Context(), channelID, startTime, endTime, event)
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Printf("  ⏹️ Поиск записей отменен: клиент отключился")
+		return
+	}
+	if errors.Is(err, hikvision.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		log.Printf("  ❌ Ошибка поиска записей: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(hikvisionErrorStatus(err), gin.H{
 			"error": fmt.Sprintf("Ошибка поиска записей: %v", err),
 		})
 		return
@@ -206,13 +276,112 @@ func GetRecordings(c *gin.Context) {
 
 	log.Printf("  ✅ Найдено записей: %d", len(recordings))
 
-	c.JSON(http.StatusOK, gin.H{
+	for i := range recordings {
+		recordings[i].PlaybackURI = exposeURL(c, recordings[i].PlaybackURI)
+	}
+
+	response := gin.H{
 		"recordings": recordings,
 		"count":      len(recordings),
 		"channel":    channelID,
 		"start_date": startDate,
 		"end_date":   endDate,
-	})
+		"start_time": startTime.Format(time.RFC3339),
+		"end_time":   endTime.Format(time.RFC3339),
+	}
+	if event != "" {
+		response["event"] = event
+	}
+	if paged {
+		response["next_cursor"] = nextCursor
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// allChannels - значение параметра channel для поиска по всем каналам
+const allChannels = "all"
+
+// getMultiChannelRecordings ищет записи нескольких каналов и группирует
+// результаты по каналам с отдельной ошибкой для каждого канала
+func getMultiChannelRecordings(c *gin.Context, channelParam string, startTime, endTime time.Time) {
+	var channelIDs []string
+	if channelParam == allChannels {
+		for _, channel := range VisibleChannels(c) {
+			channelIDs = append(channelIDs, channel.ID)
+		}
+	} else {
+		seen := make(map[string]bool)
+		for _, id := range strings.Split(channelParam, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" || seen[id] {
+				continue
+			}
+			if !channelAllowed(c, id) {
+				denyChannel(c, id)
+				return
+			}
+			seen[id] = true
+			channelIDs = append(channelIDs, id)
+		}
+	}
+
+	if len(channelIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Список каналов пуст"})
+		return
+	}
+	if c.Query("limit") != "" || c.Query("cursor") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Постраничный поиск доступен только для одного канала",
+		})
+		return
+	}
+
+	event, err := hikvision.ParseEvent(c.Query("event"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("📼 ПОИСК АРХИВА - Каналы: %s", strings.Join(channelIDs, ", "))
+	log.Printf("  📅 Период: %s - %s", startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+	if event != "" {
+		log.Printf("  🎯 Событие: %s", event)
+	}
+
+	results := hikvision.SearchChannels(c.Request.Context(), channelIDs, startTime, endTime, event)
+	if c.Request.Context().Err() != nil {
+		log.Printf("  ⏹️ Поиск записей отменен: клиент отключился")
+		return
+	}
+
+	total, failed := 0, 0
+	for i := range results {
+		if results[i].Err != nil {
+			log.Printf("  ❌ [%s] Ошибка поиска записей: %v", results[i].Channel, results[i].Err)
+			failed++
+			continue
+		}
+		for j := range results[i].Recordings {
+			results[i].Recordings[j].PlaybackURI = exposeURL(c, results[i].Recordings[j].PlaybackURI)
+		}
+		total += results[i].Count
+	}
+
+	log.Printf("  ✅ Найдено записей: %d, каналов с ошибками: %d из %d", total, failed, len(results))
+
+	response := gin.H{
+		"channels":   results,
+		"count":      total,
+		"failed":     failed,
+		"start_date": c.Query("start"),
+		"end_date":   c.Query("end"),
+		"start_time": startTime.Format(time.RFC3339),
+		"end_time":   endTime.Format(time.RFC3339),
+	}
+	if event != "" {
+		response["event"] = event
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // GetPlaybackURL получает URL для воспроизведения архивной записи
@@ -220,23 +389,19 @@ func GetPlaybackURL(c *gin.Context) {
 	channelID := c.Query("channel")
 	startTime := c.Query("start")
 	endTime := c.Query("end")
+	playbackURI := c.Query("uri")
 
-	if channelID == "" || startTime == "" {
+	if channelID == "" || (startTime == "" && playbackURI == "") {
 		log.Printf("❌ Запрос URL воспроизведения без обязательных параметров")
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Не указаны обязательные параметры (channel, start)",
+			"error": "Не указаны обязательные параметры (channel, start или uri)",
 		})
 		return
 	}
 
-	// Если конечное время не указано, добавляем час к начальному
-	if endTime == "" {
-		// Парсим время и добавляем 1 час
-		if t, err := time.Parse("2006-01-02T15:04:05Z", startTime); err == nil {
-			endTime = t.Add(time.Hour).Format("2006-01-02T15:04:05Z")
-		} else {
-			endTime = startTime
-		}
+	if !channelAllowed(c, channelID) {
+		denyChannel(c, channelID)
+		return
 	}
 
 	// Получаем информацию о канале для логирования
@@ -253,10 +418,10 @@ func GetPlaybackURL(c *gin.Context) {
 	log.Printf("  🌐 Базовый RTSP: %s", liveRTSP)
 
 	// Получаем URL для воспроизведения
-	playbackURL, err := hikvision.GetPlaybackURL(channelID, startTime, endTime)
+	playbackURL, err := playbackURL(c.Request.Context(), channelID, playbackURI, startTime, endTime)
 	if err != nil {
 		log.Printf("  ❌ Ошибка получения URL: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(playbackErrorStatus(err), gin.H{
 			"error": fmt.Sprintf("Ошибка получения URL: %v", err),
 		})
 		return
@@ -265,7 +430,7 @@ func GetPlaybackURL(c *gin.Context) {
 	log.Printf("  ✅ Архивный RTSP URL: %s", playbackURL)
 
 	c.JSON(http.StatusOK, gin.H{
-		"url":        playbackURL,
+		"url":        exposeURL(c, playbackURL),
 		"channel":    channelID,
 		"start_time": startTime,
 		"end_time":   endTime,
@@ -276,38 +441,113 @@ func GetPlaybackURL(c *gin.Context) {
 // HandlePlaybackWebRTC обрабатывает WebRTC для воспроизведения архива
 func HandlePlaybackWebRTC(c *gin.Context) {
 	var requestData struct {
-		Offer map[string]interface{} `json:"offer"`
-		URL   string                 `json:"url"`
+		Offer   go2rtc.SessionDescription `json:"offer"`
+		Channel string                    `json:"channel"`
+		Start   string                    `json:"start"`
+		End     string                    `json:"end"`
+		URI     string                    `json:"uri"` // playbackURI из результатов поиска
 	}
 
-	if err := c.ShouldBindJSON(&requestData); err != nil {
+	if err := c.ShouldBindJSON(&requestData); err != nil || requestData.Offer.SDP == "" {
 		log.Printf("❌ WebRTC Playback: ошибка чтения данных - %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
 		return
 	}
 
-	log.Printf("🎯 WebRTC PLAYBACK запрос")
-	log.Printf("  🌐 Архивный URL: %s", requestData.URL)
+	if requestData.Channel == "" || (requestData.URI == "" && (requestData.Start == "" || requestData.End == "")) {
+		log.Printf("❌ WebRTC Playback: не указаны обязательные параметры")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Не указаны обязательные параметры (channel, start и end или uri)",
+		})
+		return
+	}
 
-	// Генерируем уникальный ID для потока архива
-	streamID := "playbook_" + uuid.New().String()
-	log.Printf("  🆔 Stream ID: %s", streamID)
+	if !channelAllowed(c, requestData.Channel) {
+		denyChannel(c, requestData.Channel)
+		return
+	}
 
-	// Если go2rtc включен, возвращаем SDP ответ
-	if config.IsGo2RTCEnabled() {
-		log.Printf("  ✅ go2rtc включен - возвращаем WebRTC ответ")
-		c.JSON(http.StatusOK, gin.H{
-			"type":      "answer",
-			"stream_id": streamID,
-			"sdp":       generateDummySDP(),
-		})
-	} else {
-		// Заглушка для случая, когда go2rtc отключен
+	log.Printf("🎯 WebRTC PLAYBACK запрос - Канал %s", requestData.Channel)
+	log.Printf("  ⏰ Время: %s - %s", requestData.Start, requestData.End)
+
+	if !config.IsGo2RTCEnabled() {
 		log.Printf("  ❌ go2rtc отключен - WebRTC недоступен")
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"error": "WebRTC сервис недоступен для воспроизведения архива",
 		})
+		return
+	}
+
+	// Формируем RTSP URL архива
+	playbackURL, err := playbackURL(c.Request.Context(), requestData.Channel, requestData.URI, requestData.Start, requestData.End)
+	if err != nil {
+		log.Printf("  ❌ Ошибка получения URL: %v", err)
+		c.JSON(playbackErrorStatus(err), gin.H{
+			"error": fmt.Sprintf("Ошибка получения URL: %v", err),
+		})
+		return
+	}
+
+	// Регистрируем временный поток в go2rtc
+	streamID := go2rtc.PlaybackStreamPrefix + uuid.New().String()
+	log.Printf("  🆔 Stream ID: %s", streamID)
+
+	if err := go2rtc.RegisterPlaybackStream(streamID, playbackURL, sessionOwner(c)); err != nil {
+		log.Printf("  ❌ Ошибка регистрации потока: %v", err)
+		c.JSON(go2rtcErrorStatus(err), gin.H{
+			"error": fmt.Sprintf("Ошибка регистрации потока: %v", err),
+		})
+		return
+	}
+
+	answer, err := go2rtc.ExchangeSDP(streamID, requestData.Offer)
+	if err != nil {
+		log.Printf("  ❌ Ошибка обмена SDP: %v", err)
+		if releaseErr := go2rtc.ReleasePlaybackStream(streamID); releaseErr != nil {
+			log.Printf("  ⚠️ Ошибка удаления потока: %v", releaseErr)
+		}
+		c.JSON(go2rtcErrorStatus(err), gin.H{
+			"error": fmt.Sprintf("Ошибка WebRTC: %v", err),
+		})
+		return
+	}
+
+	log.Printf("  ✅ WebRTC воспроизведение архива запущено")
+
+	c.JSON(http.StatusOK, gin.H{
+		"type":      answer.Type,
+		"sdp":       answer.SDP,
+		"stream_id": streamID,
+	})
+}
+
+// StopPlaybackWebRTC удаляет временный поток воспроизведения архива.
+// Пользователь может остановить только свой поток, администратор - любой.
+func StopPlaybackWebRTC(c *gin.Context) {
+	streamID := c.Param("id")
+
+	log.Printf("⏹️ WebRTC PLAYBACK остановка - %s", streamID)
+
+	owner, err := go2rtc.PlaybackStreamOwner(streamID)
+	if filter := sessionFilter(c); err == nil && filter != "" && owner != filter {
+		// Чужой поток не отличается от несуществующего
+		err = fmt.Errorf("%w: %s", go2rtc.ErrStreamNotFound, streamID)
+	}
+	if err != nil {
+		log.Printf("  ❌ Поток не найден: %v", err)
+		c.JSON(go2rtcErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := go2rtc.ReleasePlaybackStream(streamID); err != nil {
+		log.Printf("  ❌ Ошибка удаления потока: %v", err)
+		c.JSON(go2rtcErrorStatus(err), gin.H{
+			"error": fmt.Sprintf("Ошибка удаления потока: %v", err),
+		})
+		return
 	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "stream_id": streamID})
 }
 
 // GetSnapshot получает снимок с камеры
@@ -319,6 +559,11 @@ func GetSnapshot(c *gin.Context) {
 		return
 	}
 
+	if !channelAllowed(c, channelID) {
+		denyChannel(c, channelID)
+		return
+	}
+
 	// Получаем информацию о канале для логирования
 	channel := config.GetChannelByID(channelID)
 	channelName := "Неизвестный канал"
@@ -332,10 +577,14 @@ func GetSnapshot(c *gin.Context) {
 	log.Printf("  🌐 RTSP источник: %s", rtspURL)
 
 	// Получаем снимок через Hikvision API
-	imageData, err := hikvision.GetSnapshot(channelID)
+	imageData, err := hikvision.GetSnapshot(c.Request.Context(), channelID)
+	if errors.Is(err, context.Canceled) {
+		log.Printf("  ⏹️ Получение снимка отменено: клиент отключился")
+		return
+	}
 	if err != nil {
 		log.Printf("  ❌ Ошибка получения снимка: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(hikvisionErrorStatus(err), gin.H{
 			"error": fmt.Sprintf("Ошибка получения снимка: %v", err),
 		})
 		return
@@ -349,32 +598,91 @@ func GetSnapshot(c *gin.Context) {
 	c.Data(http.StatusOK, "image/jpeg", imageData)
 }
 
-// TestCameraConnection тестирует подключение к камере
+// TestCameraConnection тестирует подключение к устройствам.
+// Параметр device ограничивает проверку одним устройством.
 func TestCameraConnection(c *gin.Context) {
-	ip, username, _, port := config.GetHikvisionCredentials()
+	devices := config.GetDevices()
+	if deviceID := c.Query("device"); deviceID != "" {
+		device := config.GetDevice(deviceID)
+		if device == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Устройство не найдено"})
+			return
+		}
+		devices = []config.Device{*device}
+	}
 
-	log.Printf("🔍 ТЕСТ ПОДКЛЮЧЕНИЯ к камере")
-	log.Printf("  🌐 IP: %s:%d", ip, port)
-	log.Printf("  👤 Пользователь: %s", username)
+	log.Printf("🔍 ТЕСТ ПОДКЛЮЧЕНИЯ к устройствам: %d", len(devices))
+
+	results := make([]gin.H, 0, len(devices))
+	failed := 0
+	for i := range devices {
+		device := &devices[i]
+		log.Printf("  🌐 [%s] %s, RTSP порт %d, пользователь: %s", device.ID, device.ISAPIBaseURL(), device.RTSPPort, device.Username)
+
+		result := gin.H{"device": device.ID, "name": device.Name, "status": "ok"}
+		if err := network.TestCameraConnection(device); err != nil {
+			log.Printf("  ❌ [%s] Ошибка подключения RTSP: %v", device.ID, err)
+			result["status"] = "error"
+			result["error"] = err.Error()
+			failed++
+		} else if device.Vendor == config.VendorHikvision {
+			// Веб-сервер (ISAPI) работает на отдельном порту
+			if err := hikvision.TestConnection(c.Request.Context(), device); err != nil {
+				log.Printf("  ❌ [%s] Ошибка подключения ISAPI: %v", device.ID, err)
+				result["status"] = "error"
+				result["error"] = err.Error()
+				failed++
+			}
+		}
+		results = append(results, result)
+	}
 
-	err := network.TestCameraConnection()
-	if err != nil {
-		log.Printf("  ❌ Ошибка подключения: %v", err)
+	if failed > 0 {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"status": "error",
-			"error":  err.Error(),
+			"status":  "error",
+			"error":   fmt.Sprintf("Недоступно устройств: %d из %d", failed, len(devices)),
+			"devices": results,
 		})
 		return
 	}
 
-	log.Printf("  ✅ Подключение к камере успешно")
+	log.Printf("  ✅ Подключение к устройствам успешно")
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "ok",
 		"message": "Подключение к камере успешно",
+		"devices": results,
 	})
 }
 
-// ProxyToGo2RTC проксирует запросы к go2rtc
+// SyncStreams перечитывает конфигурацию и синхронизирует потоки go2rtc
+func SyncStreams(c *gin.Context) {
+	log.Printf("🔄 СИНХРОНИЗАЦИЯ потоков go2rtc")
+
+	if _, err := config.Load(); err != nil {
+		log.Printf("  ❌ Ошибка загрузки конфигурации: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Ошибка загрузки конфигурации: %v", err),
+		})
+		return
+	}
+
+	changes, err := go2rtc.NewManager().UpdateStreams()
+	if err != nil {
+		log.Printf("  ❌ Ошибка синхронизации: %v", err)
+		c.JSON(go2rtcErrorStatus(err), gin.H{
+			"error": fmt.Sprintf("Ошибка синхронизации потоков: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"changes": changes,
+		"count":   len(changes),
+	})
+}
+
+// ProxyToGo2RTC проксирует запросы к go2rtc. Маршрут доступен только
+// администратору, поэтому доступ к каналам здесь не проверяется.
 func ProxyToGo2RTC(c *gin.Context) {
 	// Создаем URL для go2rtc
 	targetURL := fmt.Sprintf("http://localhost:%d", config.GetGo2RTCPort())
@@ -399,32 +707,136 @@ func ProxyToGo2RTC(c *gin.Context) {
 	proxy.ServeHTTP(c.Writer, c.Request)
 }
 
-// generateWebRTCSDP генерирует SDP ответ для WebRTC
-func generateWebRTCSDP(channelID string) string {
-	return fmt.Sprintf(`v=0
-o=- %d 0 IN IP4 127.0.0.1
-s=TeleOko Stream %s
-t=0 0
-a=group:BUNDLE 0
-a=msid-semantic: WMS
-m=video 9 UDP/TLS/RTP/SAVPF 96
-c=IN IP4 0.0.0.0
-a=rtcp:9 IN IP4 0.0.0.0
-a=ice-ufrag:teleoko%s
-a=ice-pwd:teleoko%s123
-a=fingerprint:sha-256 AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99
-a=setup:actpass
-a=mid:0
-a=extmap:1 urn:ietf:params:rtp-hdrext:toffset
-a=recvonly
-a=rtpmap:96 VP8/90000
-a=rtcp-fb:96 nack
-a=rtcp-fb:96 nack pli
-a=rtcp-fb:96 goog-remb`,
-		time.Now().Unix(), channelID, channelID, channelID)
+// rawURLsRequested проверяет, запросил ли администратор URL с паролями.
+// Без аутентификации любой клиент считается администратором.
+func rawURLsRequested(c *gin.Context) bool {
+	raw := c.Query("raw")
+	if raw != "1" && raw != "true" {
+		return false
+	}
+
+	user := auth.GetCurrentUser(c)
+	return user == nil || user.Role == auth.RoleAdmin
+}
+
+// exposeURL возвращает URL для ответа API, по умолчанию без пароля
+func exposeURL(c *gin.Context, rawURL string) string {
+	if rawURLsRequested(c) {
+		return rawURL
+	}
+	return redact.URL(rawURL)
+}
+
+// CanArchive проверяет, есть ли у текущего пользователя доступ к архиву
+// и журналу событий (без авторизации доступ есть у всех)
+func CanArchive(c *gin.Context) bool {
+	user := auth.GetCurrentUser(c)
+	return user == nil || user.Role.HasPermission(auth.PermArchive)
+}
+
+// VisibleChannels возвращает каналы, доступные текущему пользователю
+func VisibleChannels(c *gin.Context) []config.Channel {
+	channels := config.GetChannels()
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		return channels
+	}
+
+	visible := make([]config.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if user.CanAccessChannel(channel.ID) {
+			visible = append(visible, channel)
+		}
+	}
+	return visible
 }
 
-// generateDummySDP генерирует базовую заглушку SDP ответа
-func generateDummySDP() string {
-	return generateWebRTCSDP("default")
+// channelAllowed проверяет доступ текущего пользователя к каналу.
+// Без аутентификации доступны все каналы.
+func channelAllowed(c *gin.Context, channelID string) bool {
+	user := auth.GetCurrentUser(c)
+	return user == nil || user.CanAccessChannel(channelID)
+}
+
+// denyChannel отвечает отказом в доступе к каналу
+func denyChannel(c *gin.Context, channelID string) {
+	username := ""
+	if user := auth.GetCurrentUser(c); user != nil {
+		username = user.Username
+	}
+	log.Printf("⛔ Доступ к каналу %s запрещен для пользователя %s", channelID, username)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Нет доступа к каналу"})
+}
+
+// go2rtcErrorStatus подбирает HTTP статус для ошибки go2rtc
+func go2rtcErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, go2rtc.ErrStreamNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, go2rtc.ErrTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// playbackURL возвращает RTSP URL архива: по playbackURI из результатов
+// поиска, если он передан, иначе по времени начала и окончания.
+// Без времени окончания воспроизводится час записи от начала.
+func playbackURL(ctx context.Context, channelID, playbackURI, start, end string) (string, error) {
+	if playbackURI != "" {
+		return hikvision.GetPlaybackURLFromURI(channelID, playbackURI)
+	}
+
+	var startTime, endTime time.Time
+	var err error
+	if end == "" {
+		startTime, err = timeutil.Parse(start, time.Local)
+		endTime = startTime.Add(time.Hour)
+	} else {
+		startTime, endTime, err = timeutil.ParseRange(start, end, time.Local)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return hikvision.GetPlaybackURL(ctx, channelID, startTime, endTime)
+}
+
+// parseTimeRange разбирает интервал запроса; время без часового пояса
+// считается временем сервера. При неверном значении отвечает 400.
+func parseTimeRange(c *gin.Context, start, end string) (time.Time, time.Time, bool) {
+	startTime, endTime, err := timeutil.ParseRange(start, end, time.Local)
+	if err != nil {
+		log.Printf("❌ Неверный интервал времени: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return time.Time{}, time.Time{}, false
+	}
+	return startTime, endTime, true
+}
+
+// playbackErrorStatus подбирает HTTP статус для ошибки формирования URL архива
+func playbackErrorStatus(err error) int {
+	if errors.Is(err, hikvision.ErrInvalidPlaybackURI) ||
+		errors.Is(err, timeutil.ErrInvalidTime) || errors.Is(err, timeutil.ErrInvalidRange) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// hikvisionErrorStatus подбирает HTTP статус для ошибки ISAPI устройства
+func hikvisionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, hikvision.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, hikvision.ErrDeviceBusy):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, hikvision.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, hikvision.ErrAuth):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
 }
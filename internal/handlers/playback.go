@@ -0,0 +1,465 @@
+// internal/handlers/playback.go
+package handlers
+
+import (
+	"TeleOko/internal/auth"
+	"TeleOko/internal/config"
+	"TeleOko/internal/go2rtc"
+	"TeleOko/internal/playback"
+	"TeleOko/internal/timeutil"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreatePlaybackSession создает сессию воспроизведения архива
+func CreatePlaybackSession(c *gin.Context) {
+	var req struct {
+		Channel string `json:"channel"`
+		Start   string `json:"start"`
+		End     string `json:"end"`
+		Speed   int    `json:"speed"`
+	}
+	// Параметры можно передать в строке запроса: так открываются ссылки
+	// playback_url из журнала событий. Поля тела запроса важнее.
+	req.Channel, req.Start, req.End = c.Query("channel"), c.Query("start"), c.Query("end")
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Неверный запрос: %v", err)})
+			return
+		}
+	}
+	if req.Channel == "" || req.Start == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указаны обязательные параметры (channel, start)"})
+		return
+	}
+
+	if !channelAllowed(c, req.Channel) {
+		denyChannel(c, req.Channel)
+		return
+	}
+
+	if !config.IsGo2RTCEnabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "go2rtc отключен - воспроизведение архива недоступно"})
+		return
+	}
+
+	startTime, endTime, ok := parseTimeRange(c, req.Start, req.End)
+	if !ok {
+		return
+	}
+	if req.Speed == 0 {
+		req.Speed = 1
+	}
+
+	session, err := playback.Create(c.Request.Context(), sessionOwner(c), req.Channel, startTime, endTime, req.Speed)
+	if err != nil {
+		log.Printf("❌ Ошибка создания сессии воспроизведения: %v", err)
+		c.JSON(playbackSessionErrorStatus(err), gin.H{"error": fmt.Sprintf("Ошибка создания сессии: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusCreated, session.State())
+}
+
+// ListPlaybackSessions возвращает сессии воспроизведения пользователя
+func ListPlaybackSessions(c *gin.Context) {
+	sessions := playback.List(sessionFilter(c))
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+// GetPlaybackSession возвращает состояние сессии
+func GetPlaybackSession(c *gin.Context) {
+	session, ok := lookupPlaybackSession(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, session.State())
+}
+
+// SeekPlaybackSession переходит к указанному времени записи
+func SeekPlaybackSession(c *gin.Context) {
+	t, ok := bindSeekTime(c)
+	if !ok {
+		return
+	}
+
+	session, ok := lookupPlaybackSession(c)
+	if !ok {
+		return
+	}
+
+	if err := session.Seek(c.Request.Context(), t); err != nil {
+		respondPlaybackSessionError(c, "перехода", err)
+		return
+	}
+	c.JSON(http.StatusOK, session.State())
+}
+
+// PausePlaybackSession ставит воспроизведение на паузу
+func PausePlaybackSession(c *gin.Context) {
+	session, ok := lookupPlaybackSession(c)
+	if !ok {
+		return
+	}
+
+	session.Pause()
+	c.JSON(http.StatusOK, session.State())
+}
+
+// ResumePlaybackSession продолжает воспроизведение после паузы
+func ResumePlaybackSession(c *gin.Context) {
+	session, ok := lookupPlaybackSession(c)
+	if !ok {
+		return
+	}
+
+	if err := session.Resume(c.Request.Context()); err != nil {
+		respondPlaybackSessionError(c, "продолжения", err)
+		return
+	}
+	c.JSON(http.StatusOK, session.State())
+}
+
+// SetPlaybackSessionSpeed меняет скорость воспроизведения (1, 2, 4, 8)
+func SetPlaybackSessionSpeed(c *gin.Context) {
+	speed, ok := bindSpeed(c)
+	if !ok {
+		return
+	}
+
+	session, ok := lookupPlaybackSession(c)
+	if !ok {
+		return
+	}
+
+	if err := session.SetSpeed(c.Request.Context(), speed); err != nil {
+		respondPlaybackSessionError(c, "смены скорости", err)
+		return
+	}
+	c.JSON(http.StatusOK, session.State())
+}
+
+// PlaybackSessionOffer подключает плеер к потоку сессии по WebRTC.
+// Вызывается после создания сессии, перехода, продолжения и смены скорости.
+func PlaybackSessionOffer(c *gin.Context) {
+	session, ok := lookupPlaybackSession(c)
+	if !ok {
+		return
+	}
+	exchangePlaybackOffer(c, session.State().StreamID)
+}
+
+// DeletePlaybackSession завершает сессию воспроизведения
+func DeletePlaybackSession(c *gin.Context) {
+	if err := playback.Delete(c.Param("id"), sessionFilter(c)); err != nil {
+		c.JSON(playbackSessionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "stopped"})
+}
+
+// CreatePlaybackGroup запускает синхронное воспроизведение нескольких каналов
+func CreatePlaybackGroup(c *gin.Context) {
+	var req struct {
+		Channels []string `json:"channels"`
+		Start    string   `json:"start"`
+		End      string   `json:"end"`
+		Speed    int      `json:"speed"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Channels) == 0 || req.Start == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указаны обязательные параметры (channels, start)"})
+		return
+	}
+
+	var channelIDs []string
+	seen := make(map[string]bool)
+	for _, id := range req.Channels {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if !channelAllowed(c, id) {
+			denyChannel(c, id)
+			return
+		}
+		seen[id] = true
+		channelIDs = append(channelIDs, id)
+	}
+
+	if !config.IsGo2RTCEnabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "go2rtc отключен - воспроизведение архива недоступно"})
+		return
+	}
+
+	startTime, endTime, ok := parseTimeRange(c, req.Start, req.End)
+	if !ok {
+		return
+	}
+	if req.Speed == 0 {
+		req.Speed = 1
+	}
+
+	group, err := playback.CreateGroup(c.Request.Context(), sessionOwner(c), channelIDs, startTime, endTime, req.Speed)
+	if err != nil {
+		log.Printf("❌ Ошибка создания группы воспроизведения: %v", err)
+		c.JSON(playbackSessionErrorStatus(err), gin.H{"error": fmt.Sprintf("Ошибка создания группы: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusCreated, group.State())
+}
+
+// ListPlaybackGroups возвращает группы воспроизведения пользователя
+func ListPlaybackGroups(c *gin.Context) {
+	groups := playback.ListGroups(sessionFilter(c))
+	c.JSON(http.StatusOK, gin.H{
+		"groups": groups,
+		"count":  len(groups),
+	})
+}
+
+// GetPlaybackGroup возвращает общую позицию группы и расхождение потоков
+func GetPlaybackGroup(c *gin.Context) {
+	group, ok := lookupPlaybackGroup(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, group.State())
+}
+
+// SeekPlaybackGroup переводит все потоки группы к указанному времени
+func SeekPlaybackGroup(c *gin.Context) {
+	t, ok := bindSeekTime(c)
+	if !ok {
+		return
+	}
+
+	group, ok := lookupPlaybackGroup(c)
+	if !ok {
+		return
+	}
+
+	if err := group.Seek(c.Request.Context(), t); err != nil {
+		respondPlaybackSessionError(c, "перехода", err)
+		return
+	}
+	c.JSON(http.StatusOK, group.State())
+}
+
+// PausePlaybackGroup ставит все потоки группы на паузу
+func PausePlaybackGroup(c *gin.Context) {
+	group, ok := lookupPlaybackGroup(c)
+	if !ok {
+		return
+	}
+
+	group.Pause()
+	c.JSON(http.StatusOK, group.State())
+}
+
+// ResumePlaybackGroup продолжает воспроизведение всех потоков группы
+func ResumePlaybackGroup(c *gin.Context) {
+	group, ok := lookupPlaybackGroup(c)
+	if !ok {
+		return
+	}
+
+	if err := group.Resume(c.Request.Context()); err != nil {
+		respondPlaybackSessionError(c, "продолжения", err)
+		return
+	}
+	c.JSON(http.StatusOK, group.State())
+}
+
+// SetPlaybackGroupSpeed меняет скорость всех потоков группы
+func SetPlaybackGroupSpeed(c *gin.Context) {
+	speed, ok := bindSpeed(c)
+	if !ok {
+		return
+	}
+
+	group, ok := lookupPlaybackGroup(c)
+	if !ok {
+		return
+	}
+
+	if err := group.SetSpeed(c.Request.Context(), speed); err != nil {
+		respondPlaybackSessionError(c, "смены скорости", err)
+		return
+	}
+	c.JSON(http.StatusOK, group.State())
+}
+
+// ReportPlaybackGroupPositions принимает позиции, которые показывают плееры
+// группы, и возвращает расхождение каждого потока с общей шкалой
+func ReportPlaybackGroupPositions(c *gin.Context) {
+	var req struct {
+		Positions map[string]string `json:"positions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Positions) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указаны позиции (positions)"})
+		return
+	}
+
+	positions := make(map[string]time.Time, len(req.Positions))
+	for channelID, value := range req.Positions {
+		t, err := timeutil.Parse(value, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("канал %s: %v", channelID, err)})
+			return
+		}
+		positions[channelID] = t
+	}
+
+	group, ok := lookupPlaybackGroup(c)
+	if !ok {
+		return
+	}
+
+	if err := group.Report(positions); err != nil {
+		c.JSON(playbackSessionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, group.State())
+}
+
+// PlaybackGroupOffer подключает плеер к потоку канала группы по WebRTC
+func PlaybackGroupOffer(c *gin.Context) {
+	group, ok := lookupPlaybackGroup(c)
+	if !ok {
+		return
+	}
+
+	member, err := group.Member(c.Param("channel"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	exchangePlaybackOffer(c, member.State().StreamID)
+}
+
+// DeletePlaybackGroup завершает группу воспроизведения
+func DeletePlaybackGroup(c *gin.Context) {
+	if err := playback.DeleteGroup(c.Param("id"), sessionFilter(c)); err != nil {
+		c.JSON(playbackSessionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "stopped"})
+}
+
+// exchangePlaybackOffer обменивается SDP с потоком архива go2rtc
+func exchangePlaybackOffer(c *gin.Context, streamID string) {
+	var offer go2rtc.SessionDescription
+	if err := c.ShouldBindJSON(&offer); err != nil || offer.SDP == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	answer, err := go2rtc.ExchangeSDP(streamID, offer)
+	if err != nil {
+		log.Printf("❌ Ошибка обмена SDP для потока %s: %v", streamID, err)
+		c.JSON(go2rtcErrorStatus(err), gin.H{"error": fmt.Sprintf("Ошибка WebRTC: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, answer)
+}
+
+// bindSeekTime читает время перехода из тела запроса; при ошибке отвечает 400
+func bindSeekTime(c *gin.Context) (time.Time, bool) {
+	var req struct {
+		Time string `json:"time"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Time == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указано время (time)"})
+		return time.Time{}, false
+	}
+
+	t, err := timeutil.Parse(req.Time, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// bindSpeed читает скорость из тела запроса; при ошибке отвечает 400
+func bindSpeed(c *gin.Context) (int, bool) {
+	var req struct {
+		Speed int `json:"speed"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указана скорость (speed)"})
+		return 0, false
+	}
+	return req.Speed, true
+}
+
+// lookupPlaybackGroup находит группу из параметра id; при ошибке отвечает 404
+func lookupPlaybackGroup(c *gin.Context) (*playback.Group, bool) {
+	group, err := playback.GetGroup(c.Param("id"), sessionFilter(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return group, true
+}
+
+// lookupPlaybackSession находит сессию из параметра id; при ошибке отвечает 404
+func lookupPlaybackSession(c *gin.Context) (*playback.Session, bool) {
+	session, err := playback.Get(c.Param("id"), sessionFilter(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return session, true
+}
+
+// sessionOwner возвращает владельца новой сессии
+func sessionOwner(c *gin.Context) string {
+	if user := auth.GetCurrentUser(c); user != nil {
+		return user.Username
+	}
+	return ""
+}
+
+// sessionFilter возвращает владельца, чьи сессии видны пользователю.
+// Администратор видит все сессии.
+func sessionFilter(c *gin.Context) string {
+	user := auth.GetCurrentUser(c)
+	if user == nil || user.Role == auth.RoleAdmin {
+		return ""
+	}
+	return user.Username
+}
+
+// respondPlaybackSessionError отвечает ошибкой управления сессией
+func respondPlaybackSessionError(c *gin.Context, action string, err error) {
+	log.Printf("❌ Ошибка %s сессии %s: %v", action, c.Param("id"), err)
+	c.JSON(playbackSessionErrorStatus(err), gin.H{"error": fmt.Sprintf("Ошибка %s: %v", action, err)})
+}
+
+// playbackSessionErrorStatus подбирает HTTP статус для ошибки сессии
+func playbackSessionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, playback.ErrSessionNotFound), errors.Is(err, playback.ErrGroupNotFound),
+		errors.Is(err, playback.ErrStreamNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, playback.ErrInvalidSpeed), errors.Is(err, playback.ErrOutOfRange),
+		errors.Is(err, playback.ErrGroupSize):
+		return http.StatusBadRequest
+	case errors.Is(err, go2rtc.ErrStreamNotFound), errors.Is(err, go2rtc.ErrTimeout), errors.Is(err, go2rtc.ErrUnavailable):
+		return go2rtcErrorStatus(err)
+	default:
+		return hikvisionErrorStatus(err)
+	}
+}
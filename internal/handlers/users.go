@@ -0,0 +1,128 @@
+// internal/handlers/users.go
+package handlers
+
+import (
+	"TeleOko/internal/auth"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListUsers возвращает список пользователей
+func ListUsers(c *gin.Context) {
+	users := auth.ListUsers()
+	c.JSON(http.StatusOK, gin.H{
+		"users": users,
+		"count": len(users),
+	})
+}
+
+// CreateUser добавляет пользователя
+func CreateUser(c *gin.Context) {
+	var req struct {
+		Username string    `json:"username"`
+		Password string    `json:"password"`
+		Role     auth.Role `json:"role"`
+		Channels []string  `json:"channels"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	log.Printf("👤 Создание пользователя %s [%s]", req.Username, req.Role)
+
+	if err := auth.AddUser(req.Username, req.Password, req.Role); err != nil {
+		log.Printf("  ❌ Ошибка создания пользователя: %v", err)
+		c.JSON(userErrorStatus(err), gin.H{"error": fmt.Sprintf("Ошибка создания пользователя: %v", err)})
+		return
+	}
+
+	if len(req.Channels) > 0 {
+		if err := auth.SetUserChannels(req.Username, req.Channels); err != nil {
+			log.Printf("  ❌ Ошибка назначения каналов: %v", err)
+			c.JSON(userErrorStatus(err), gin.H{"error": fmt.Sprintf("Ошибка назначения каналов: %v", err)})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, auth.LookupUser(req.Username))
+}
+
+// DeleteUser удаляет пользователя
+func DeleteUser(c *gin.Context) {
+	username := c.Param("username")
+
+	log.Printf("👤 Удаление пользователя %s", username)
+
+	if err := auth.RemoveUser(username); err != nil {
+		log.Printf("  ❌ Ошибка удаления пользователя: %v", err)
+		c.JSON(userErrorStatus(err), gin.H{"error": fmt.Sprintf("Ошибка удаления пользователя: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "username": username})
+}
+
+// ResetUserPassword задает новый пароль пользователя
+func ResetUserPassword(c *gin.Context) {
+	username := c.Param("username")
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	log.Printf("👤 Сброс пароля пользователя %s", username)
+
+	if err := auth.ResetPassword(username, req.Password); err != nil {
+		log.Printf("  ❌ Ошибка сброса пароля: %v", err)
+		c.JSON(userErrorStatus(err), gin.H{"error": fmt.Sprintf("Ошибка сброса пароля: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "username": username})
+}
+
+// SetUserChannels задает список разрешенных каналов пользователя
+func SetUserChannels(c *gin.Context) {
+	username := c.Param("username")
+
+	var req struct {
+		Channels []string `json:"channels"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	log.Printf("👤 Каналы пользователя %s: %v", username, req.Channels)
+
+	if err := auth.SetUserChannels(username, req.Channels); err != nil {
+		log.Printf("  ❌ Ошибка назначения каналов: %v", err)
+		c.JSON(userErrorStatus(err), gin.H{"error": fmt.Sprintf("Ошибка назначения каналов: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, auth.LookupUser(username))
+}
+
+// userErrorStatus подбирает HTTP статус для ошибки управления пользователями
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrUserExists):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrInvalidRole), errors.Is(err, auth.ErrLastAdmin), errors.Is(err, auth.ErrFirstAdmin):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,84 @@
+// internal/handlers/webhooks.go
+package handlers
+
+import (
+	"TeleOko/internal/webhook"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Параметры журнала недоставленных событий
+const (
+	defaultDeadLettersLimit = 100
+	maxDeadLettersLimit     = 1000
+)
+
+// ListWebhooks возвращает настроенные вебхуки и статистику доставки
+func ListWebhooks(c *gin.Context) {
+	hooks := webhook.List()
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": hooks,
+		"count":    len(hooks),
+	})
+}
+
+// GetWebhookDeadLetters возвращает последние недоставленные события
+func GetWebhookDeadLetters(c *gin.Context) {
+	limit := defaultDeadLettersLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxDeadLettersLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Параметр limit должен быть числом от 1 до %d", maxDeadLettersLimit),
+			})
+			return
+		}
+	}
+
+	entries, err := webhook.DeadLetters(limit)
+	if err != nil {
+		log.Printf("❌ Ошибка чтения журнала недоставленных событий: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": entries,
+		"count":        len(entries),
+	})
+}
+
+// TestWebhook отправляет на вебхук проверочное событие и возвращает результат
+func TestWebhook(c *gin.Context) {
+	name := c.Param("name")
+	if err := webhook.Test(c.Request.Context(), name); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, webhook.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("🪝 Вебхук %s: проверочное событие доставлено", name)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// GetWebhookSnapshot отдает снимок, ссылка на который отправлена в вебхуке.
+// Доступен без авторизации: имя снимка случайное, срок хранения ограничен.
+func GetWebhookSnapshot(c *gin.Context) {
+	path := webhook.SnapshotPath(c.Param("name"))
+	if path == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Снимок не найден"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.File(path)
+}
@@ -3,40 +3,95 @@ package hikvision
 
 import (
 	"TeleOko/internal/config"
-	"bytes"
+	"TeleOko/internal/redact"
+	"TeleOko/internal/timeutil"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
-// SearchRecordings ищет записи в архиве Hikvision
-func SearchRecordings(channelID, startDate, endDate string) ([]Recording, error) {
-	ip, username, password, port := config.GetHikvisionCredentials()
+// Таймауты ISAPI операций
+const (
+	searchTimeout   = 30 * time.Second // на одну страницу поиска
+	snapshotTimeout = 10 * time.Second
+	testTimeout     = 5 * time.Second
+)
 
-	// Преобразуем дату из dd.mm.yyyy в формат ISO
-	startTime, err := parseDate(startDate, "00:00:00")
-	if err != nil {
-		return nil, fmt.Errorf("ошибка парсинга даты начала: %v", err)
+// Ограничения постраничного поиска
+const (
+	searchPageSize = 50   // записей в одном запросе к устройству
+	maxSearchPages = 1000 // защита от зацикливания на ответах MORE
+	MaxSearchLimit = 1000 // максимальный размер страницы API
+)
+
+// Ошибки параметров поиска и воспроизведения
+var (
+	ErrInvalidCursor      = errors.New("неверный курсор поиска")
+	ErrInvalidPlaybackURI = errors.New("неверный playbackURI")
+	ErrInvalidEvent       = errors.New("неизвестный тип события")
+)
+
+// searchPage - результат одного запроса поиска к устройству
+type searchPage struct {
+	recordings []Recording
+	more       bool
+}
+
+// ParseEvent проверяет тип события фильтра поиска и приводит его
+// к каноническому названию. Пустая строка означает поиск всех записей.
+func ParseEvent(event string) (string, error) {
+	event = strings.ToLower(strings.TrimSpace(event))
+	if event == "" {
+		return "", nil
 	}
+	if alias, ok := eventAliases[event]; ok {
+		event = alias
+	}
+	if _, ok := eventDescriptors[event]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrInvalidEvent, event)
+	}
+	return event, nil
+}
 
-	endTime, err := parseDate(endDate, "23:59:59")
+// searchPage запрашивает одну страницу результатов поиска начиная с position
+func (c *Client) searchPage(ctx context.Context, searchID string, query SearchQuery, position, maxResults int) (*searchPage, error) {
+	event, err := ParseEvent(query.Event)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка парсинга даты окончания: %v", err)
+		return nil, err
 	}
 
+	// Устройство принимает и возвращает время в своем часовом поясе
+	loc := c.Location(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+
 	// Создаем XML запрос для поиска записей
 	searchReq := PlaybackSearchRequest{
 		XMLName:              xml.Name{Local: "CMSearchDescription"},
-		SearchID:             "1",
-		SearchResultPosition: 0,
-		MaxResults:           1000,
+		SearchID:             searchID,
+		SearchResultPosition: position,
+		MaxResults:           maxResults,
 		SearchMode:           "byTime",
-		StartTime:            startTime,
-		EndTime:              endTime,
-		Channels:             channelID,
+		StartTime:            timeutil.FormatISAPI(query.StartTime, loc),
+		EndTime:              timeutil.FormatISAPI(query.EndTime, loc),
+		Channels:             query.DeviceChannel,
+	}
+	if event != "" {
+		searchReq.Metadata = &MetadataList{Descriptors: []string{eventDescriptors[event]}}
 	}
 
 	// Сериализуем в XML
@@ -45,164 +100,330 @@ func SearchRecordings(channelID, startDate, endDate string) ([]Recording, error)
 		return nil, fmt.Errorf("ошибка создания XML запроса: %v", err)
 	}
 
-	// Создаем HTTP запрос
-	url := fmt.Sprintf("http://%s:%d/ISAPI/ContentMgmt/search", ip, port)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(xmlData))
+	body, err := c.do(ctx, "поиск записей", http.MethodPost, "/ISAPI/ContentMgmt/search", xmlData)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+		return nil, err
 	}
 
-	// Устанавливаем заголовки
-	req.Header.Set("Content-Type", "application/xml; charset=UTF-8")
-	req.SetBasicAuth(username, password)
+	// Парсим XML ответ
+	var searchResp SearchResponse
+	if err := xml.Unmarshal(body, &searchResp); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга XML ответа: %v", err)
+	}
 
-	// Выполняем запрос
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка HTTP запроса: %v", err)
+	// Преобразуем в наш формат
+	page := &searchPage{
+		recordings: make([]Recording, 0, len(searchResp.MatchList.Items)),
+		more:       strings.EqualFold(strings.TrimSpace(searchResp.ResponseStatusStrg), SearchStatusMore),
+	}
+	for _, item := range searchResp.MatchList.Items {
+		page.recordings = append(page.recordings, newRecording(item, query.DeviceChannel, loc))
 	}
-	defer resp.Body.Close()
 
-	// Читаем ответ
-	body, err := io.ReadAll(resp.Body)
+	return page, nil
+}
+
+// SearchRecordings ищет все записи канала в архиве устройства, запрашивая
+// страницы, пока устройство отвечает MORE
+func (c *Client) SearchRecordings(ctx context.Context, query SearchQuery) ([]Recording, error) {
+	searchID := uuid.New().String()
+	recordings := []Recording{}
+
+	for pages := 0; pages < maxSearchPages; pages++ {
+		page, err := c.searchPage(ctx, searchID, query, len(recordings), searchPageSize)
+		if err != nil {
+			return nil, err
+		}
+		recordings = append(recordings, page.recordings...)
+
+		// Пустая страница с MORE означает, что устройство не продвигается дальше
+		if !page.more || len(page.recordings) == 0 {
+			return recordings, nil
+		}
+	}
+
+	log.Printf("⚠️ Поиск записей канала %s остановлен после %d страниц", query.DeviceChannel, maxSearchPages)
+	return recordings, nil
+}
+
+// SearchRecordingsPage возвращает одну страницу результатов поиска.
+// Пустой cursor начинает новый поиск; возвращаемый курсор пуст,
+// если следующих страниц нет.
+func (c *Client) SearchRecordingsPage(ctx context.Context, query SearchQuery, cursor string, limit int) ([]Recording, string, error) {
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = searchPageSize
+	}
+
+	digest := c.queryDigest(query)
+	searchID, position := uuid.New().String(), 0
+	if cursor != "" {
+		var err error
+		if searchID, position, err = decodeCursor(cursor, digest); err != nil {
+			return nil, "", err
+		}
+	}
+
+	page, err := c.searchPage(ctx, searchID, query, position, limit)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения ответа: %v", err)
+		return nil, "", err
 	}
 
-	// Проверяем статус ответа
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("ошибка HTTP: %d, ответ: %s", resp.StatusCode, string(body))
+	next := ""
+	if page.more && len(page.recordings) > 0 {
+		next = encodeCursor(searchID, position+len(page.recordings), digest)
 	}
+	return page.recordings, next, nil
+}
 
-	// Парсим XML ответ
-	var searchResp SearchResponse
-	if err := xml.Unmarshal(body, &searchResp); err != nil {
-		return nil, fmt.Errorf("ошибка парсинга XML ответа: %v", err)
+// newRecording преобразует результат ISAPI поиска в запись
+func newRecording(item searchMatchItem, deviceChannel string, loc *time.Location) Recording {
+	recording := Recording{
+		StartTime:   formatRecordingTime(item.StartTime, loc),
+		EndTime:     formatRecordingTime(item.EndTime, loc),
+		Channel:     deviceChannel,
+		PlaybackURI: strings.TrimSpace(item.PlaybackURI),
+		SourceID:    strings.TrimSpace(item.SourceID),
+		TrackID:     strings.TrimSpace(item.TrackID),
+		Size:        item.FileSize,
+		RecordType:  parseRecordType(item.Metadata),
 	}
 
-	// Преобразуем в наш формат
-	recordings := make([]Recording, 0, len(searchResp.MatchList.Recordings))
-	for _, rec := range searchResp.MatchList.Recordings {
-		recording := Recording{
-			StartTime: formatTimeForAPI(rec.StartTime),
-			EndTime:   formatTimeForAPI(rec.EndTime),
-			Channel:   channelID,
+	// Большинство регистраторов передают размер только в playbackURI
+	if recording.Size == 0 && recording.PlaybackURI != "" {
+		if u, err := url.Parse(recording.PlaybackURI); err == nil {
+			recording.Size, _ = strconv.ParseInt(u.Query().Get("size"), 10, 64)
 		}
-		recordings = append(recordings, recording)
 	}
 
-	return recordings, nil
+	return recording
 }
 
-// GetPlaybackURL возвращает URL для воспроизведения архивной записи
-func GetPlaybackURL(channelID, startTime, endTime string) (string, error) {
-	ip, username, password, port := config.GetHikvisionCredentials()
+// formatRecordingTime переводит время устройства в RFC 3339 с явным
+// смещением. Нераспознанное значение возвращается как есть.
+func formatRecordingTime(value string, loc *time.Location) string {
+	t, err := timeutil.ParseISAPI(value, loc)
+	if err != nil {
+		log.Printf("⚠️ Нераспознанное время записи: %v", err)
+		return strings.TrimSpace(value)
+	}
+	return t.Format(time.RFC3339)
+}
 
-	// Формируем URL для воспроизведения архива
-	playbackURL := fmt.Sprintf("rtsp://%s:%s@%s:%d/Streaming/tracks/%s?starttime=%s&endtime=%s",
-		username, password, ip, port, channelID,
-		formatTimeForRTSP(startTime), formatTimeForRTSP(endTime))
+// parseRecordType приводит дескриптор вида recordType.meta.std-cgi.com/CMR
+// к типу записи
+func parseRecordType(descriptor string) string {
+	descriptor = strings.TrimSpace(descriptor)
+	if descriptor == "" {
+		return ""
+	}
+	if i := strings.LastIndex(descriptor, "/"); i >= 0 {
+		descriptor = descriptor[i+1:]
+	}
 
-	return playbackURL, nil
+	switch strings.ToLower(descriptor) {
+	case "cmr", "timing", "continuous", "manual", "command":
+		return RecordTypeContinuous
+	case "motion", "vmd":
+		return RecordTypeMotion
+	case "alarm", "alarminput", "alarmandmotion", "alarmormotion":
+		return RecordTypeAlarm
+	default:
+		return RecordTypeEvent
+	}
 }
 
-// GetSnapshot получает снимок с камеры
-func GetSnapshot(channelID string) ([]byte, error) {
-	ip, username, password, port := config.GetHikvisionCredentials()
+// queryDigest возвращает отпечаток параметров поиска. Он входит в курсор,
+// чтобы курсор нельзя было продолжить с другим каналом, интервалом или
+// фильтром: устройство вернуло бы страницу чужого поиска.
+func (c *Client) queryDigest(query SearchQuery) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		c.device.ID,
+		query.DeviceChannel,
+		strconv.FormatInt(query.StartTime.UnixNano(), 10),
+		strconv.FormatInt(query.EndTime.UnixNano(), 10),
+		query.Event,
+	}, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
 
-	// URL для получения снимка
-	url := fmt.Sprintf("http://%s:%d/ISAPI/Streaming/channels/%s/picture", ip, port, channelID)
+// encodeCursor упаковывает ID поиска, позицию следующей страницы
+// и отпечаток параметров поиска
+func encodeCursor(searchID string, position int, digest string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(searchID + ":" + strconv.Itoa(position) + ":" + digest))
+}
 
-	// Создаем HTTP запрос
-	req, err := http.NewRequest("GET", url, nil)
+// decodeCursor разбирает курсор, созданный encodeCursor, и проверяет,
+// что он относится к поиску с отпечатком digest
+func decodeCursor(cursor, digest string) (string, int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+		return "", 0, ErrInvalidCursor
 	}
 
-	req.SetBasicAuth(username, password)
+	parts := strings.Split(string(data), ":")
+	if len(parts) != 3 {
+		return "", 0, ErrInvalidCursor
+	}
+	searchID, positionStr, cursorDigest := parts[0], parts[1], parts[2]
+	if _, err := uuid.Parse(searchID); err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+	position, err := strconv.Atoi(positionStr)
+	if err != nil || position < 0 {
+		return "", 0, ErrInvalidCursor
+	}
+	if cursorDigest != digest {
+		return "", 0, fmt.Errorf("%w: курсор относится к поиску с другим каналом, интервалом или событием", ErrInvalidCursor)
+	}
 
-	// Выполняем запрос
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка HTTP запроса: %v", err)
+	return searchID, position, nil
+}
+
+// PlaybackURL возвращает RTSP URL архивной записи канала устройства.
+// Время передается в часовом поясе устройства.
+func (c *Client) PlaybackURL(ctx context.Context, deviceChannel string, startTime, endTime time.Time) string {
+	u := c.playbackURI(ctx, deviceChannel, startTime, endTime)
+	u.User = url.UserPassword(c.device.Username, c.device.Password)
+	return u.String()
+}
+
+// playbackURI формирует RTSP адрес архива без учетных данных
+func (c *Client) playbackURI(ctx context.Context, deviceChannel string, startTime, endTime time.Time) *url.URL {
+	loc := c.Location(ctx)
+	return &url.URL{
+		Scheme: "rtsp",
+		Host:   net.JoinHostPort(c.device.IP, strconv.Itoa(c.device.RTSPPort)),
+		Path:   "/Streaming/tracks/" + deviceChannel,
+		RawQuery: fmt.Sprintf("starttime=%s&endtime=%s",
+			timeutil.FormatRTSP(startTime, loc), timeutil.FormatRTSP(endTime, loc)),
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("ошибка HTTP: %d", resp.StatusCode)
+// PlaybackURLFromURI подставляет учетные данные и адрес устройства из
+// конфигурации в playbackURI из результатов поиска. Адрес в playbackURI
+// может быть внутренним адресом регистратора, недоступным с сервера.
+func (c *Client) PlaybackURLFromURI(deviceChannel, playbackURI string) (string, error) {
+	u, err := url.Parse(playbackURI)
+	if err != nil || !strings.EqualFold(u.Scheme, "rtsp") {
+		return "", fmt.Errorf("%w: %s", ErrInvalidPlaybackURI, redact.URL(playbackURI))
 	}
 
-	// Читаем изображение
-	imageData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения изображения: %v", err)
+	// Запись должна относиться к каналу, к которому у пользователя есть доступ
+	track := strings.Trim(strings.TrimPrefix(u.Path, "/Streaming/tracks/"), "/")
+	if !strings.HasPrefix(u.Path, "/Streaming/tracks/") || track != deviceChannel {
+		return "", fmt.Errorf("%w: запись не относится к каналу %s", ErrInvalidPlaybackURI, deviceChannel)
 	}
 
-	return imageData, nil
+	u.Scheme = "rtsp"
+	u.User = url.UserPassword(c.device.Username, c.device.Password)
+	u.Host = net.JoinHostPort(c.device.IP, strconv.Itoa(c.device.RTSPPort))
+	return u.String(), nil
 }
 
-// TestConnection проверяет подключение к камере
-func TestConnection() error {
-	ip, username, password, port := config.GetHikvisionCredentials()
+// Snapshot получает снимок канала устройства
+func (c *Client) Snapshot(ctx context.Context, deviceChannel string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	path := fmt.Sprintf("/ISAPI/Streaming/channels/%s/picture", deviceChannel)
+	return c.do(ctx, "получение снимка", http.MethodGet, path, nil)
+}
+
+// TestConnection проверяет доступность ISAPI и учетные данные устройства
+func (c *Client) TestConnection(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, testTimeout)
+	defer cancel()
 
 	// Пробуем получить информацию о системе
-	url := fmt.Sprintf("http://%s:%d/ISAPI/System/deviceInfo", ip, port)
+	_, err := c.do(ctx, "проверка подключения", http.MethodGet, "/ISAPI/System/deviceInfo", nil)
+	return err
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// SearchRecordings ищет записи в архиве Hikvision за интервал времени.
+// event ограничивает поиск записями по событию (пусто - все записи).
+func SearchRecordings(ctx context.Context, channelID string, startTime, endTime time.Time, event string) ([]Recording, error) {
+	client, query, err := prepareSearch(channelID, startTime, endTime, event)
 	if err != nil {
-		return fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+		return nil, err
 	}
 
-	req.SetBasicAuth(username, password)
+	recordings, err := client.SearchRecordings(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return withChannelID(recordings, channelID), nil
+}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+// SearchRecordingsPage возвращает страницу записей архива и курсор следующей
+func SearchRecordingsPage(ctx context.Context, channelID string, startTime, endTime time.Time, event, cursor string, limit int) ([]Recording, string, error) {
+	client, query, err := prepareSearch(channelID, startTime, endTime, event)
 	if err != nil {
-		return fmt.Errorf("ошибка подключения: %v", err)
+		return nil, "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("неверные учетные данные")
+	recordings, next, err := client.SearchRecordingsPage(ctx, query, cursor, limit)
+	if err != nil {
+		return nil, "", err
 	}
+	return withChannelID(recordings, channelID), next, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("ошибка HTTP: %d", resp.StatusCode)
+// prepareSearch находит клиент канала и формирует параметры поиска
+func prepareSearch(channelID string, startTime, endTime time.Time, event string) (*Client, SearchQuery, error) {
+	client, deviceChannel, err := ClientForChannel(channelID)
+	if err != nil {
+		return nil, SearchQuery{}, err
 	}
 
-	return nil
+	return client, SearchQuery{
+		DeviceChannel: deviceChannel,
+		StartTime:     startTime,
+		EndTime:       endTime,
+		Event:         event,
+	}, nil
 }
 
-// parseDate преобразует дату из формата dd.mm.yyyy в ISO формат
-func parseDate(dateStr, timeStr string) (string, error) {
-	// Разбираем дату формата dd.mm.yyyy
-	parts := strings.Split(dateStr, ".")
-	if len(parts) != 3 {
-		return "", fmt.Errorf("неверный формат даты: %s", dateStr)
+// withChannelID заменяет номер канала на устройстве на ID канала приложения
+func withChannelID(recordings []Recording, channelID string) []Recording {
+	for i := range recordings {
+		recordings[i].Channel = channelID
 	}
+	return recordings
+}
 
-	// Переставляем в формат yyyy-mm-dd
-	isoDate := fmt.Sprintf("%s-%s-%s", parts[2], parts[1], parts[0])
+// GetPlaybackURL возвращает URL для воспроизведения архивной записи
+func GetPlaybackURL(ctx context.Context, channelID string, startTime, endTime time.Time) (string, error) {
+	client, deviceChannel, err := ClientForChannel(channelID)
+	if err != nil {
+		return "", err
+	}
+	return client.PlaybackURL(ctx, deviceChannel, startTime, endTime), nil
+}
 
-	// Добавляем время
-	return fmt.Sprintf("%sT%s", isoDate, timeStr), nil
+// GetPlaybackURLFromURI возвращает URL воспроизведения по playbackURI из
+// результатов поиска
+func GetPlaybackURLFromURI(channelID, playbackURI string) (string, error) {
+	client, deviceChannel, err := ClientForChannel(channelID)
+	if err != nil {
+		return "", err
+	}
+	return client.PlaybackURLFromURI(deviceChannel, playbackURI)
 }
 
-// formatTimeForAPI форматирует время для API запросов
-func formatTimeForAPI(timeStr string) string {
-	// Входящий формат: 2006-01-02T15:04:05Z
-	// Выходящий формат: 2006-01-02T15:04:05Z (без изменений)
-	return timeStr
+// GetSnapshot получает снимок с камеры
+func GetSnapshot(ctx context.Context, channelID string) ([]byte, error) {
+	client, deviceChannel, err := ClientForChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return client.Snapshot(ctx, deviceChannel)
 }
 
-// formatTimeForRTSP форматирует время для RTSP URL
-func formatTimeForRTSP(timeStr string) string {
-	// Преобразуем ISO время в формат для RTSP
-	// Из: 2006-01-02T15:04:05Z
-	// В: 20060102T150405Z
-	timeStr = strings.ReplaceAll(timeStr, "-", "")
-	timeStr = strings.ReplaceAll(timeStr, ":", "")
-	return timeStr
+// TestConnection проверяет подключение к устройству
+func TestConnection(ctx context.Context, device *config.Device) error {
+	client, err := ClientFor(device)
+	if err != nil {
+		return err
+	}
+	return client.TestConnection(ctx)
 }
@@ -0,0 +1,222 @@
+// internal/hikvision/client.go
+package hikvision
+
+import (
+	"TeleOko/internal/config"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ошибки обращения к устройству
+var (
+	ErrAuth              = errors.New("устройство отклонило учетные данные")
+	ErrNotFound          = errors.New("ресурс не найден на устройстве")
+	ErrDeviceBusy        = errors.New("устройство занято")
+	ErrTimeout           = errors.New("устройство не ответило вовремя")
+	ErrUnsupportedVendor = errors.New("устройство не поддерживает Hikvision ISAPI")
+)
+
+// DeviceError - ошибка ISAPI запроса с HTTP статусом устройства
+type DeviceError struct {
+	Op         string
+	StatusCode int
+	Body       string
+	Err        error
+}
+
+// Error возвращает описание ошибки
+func (e *DeviceError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: %v (HTTP %d)", e.Op, e.Err, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+// Unwrap позволяет использовать errors.Is с ошибками пакета
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// Общие транспорты с keep-alive для всех устройств
+var (
+	sharedTransport   = newTransport(false)
+	insecureTransport = newTransport(true)
+)
+
+// newTransport создает транспорт, при необходимости без проверки сертификата
+func newTransport(insecure bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 4
+	if insecure {
+		// Регистраторы обычно используют самоподписанные сертификаты
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return transport
+}
+
+// Client - клиент ISAPI одного устройства Hikvision
+type Client struct {
+	device  config.Device
+	baseURL string
+	http    *http.Client
+
+	// Кеш часового пояса устройства
+	locMu      sync.Mutex
+	loc        *time.Location
+	locExpires time.Time
+	locFetch   chan struct{} // закрывается по завершении текущего запроса
+}
+
+// NewClient создает клиент для устройства
+func NewClient(device config.Device) *Client {
+	base := sharedTransport
+	if device.InsecureSkipVerify {
+		base = insecureTransport
+	}
+
+	return &Client{
+		device:  device,
+		baseURL: device.ISAPIBaseURL(),
+		http: &http.Client{
+			Transport: &authTransport{
+				username: device.Username,
+				password: device.Password,
+				base:     base,
+				state:    &authState{},
+			},
+		},
+	}
+}
+
+// Device возвращает настройки устройства клиента
+func (c *Client) Device() config.Device {
+	return c.device
+}
+
+// do выполняет ISAPI запрос и преобразует ответ устройства в ошибку пакета
+func (c *Client) do(ctx context.Context, op, method, path string, body []byte) ([]byte, error) {
+	resp, err := c.send(ctx, op, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &DeviceError{Op: op, Err: classifyRequestError(ctx, err)}
+	}
+	return data, nil
+}
+
+// send выполняет ISAPI запрос и возвращает успешный ответ без чтения тела.
+// Тело ответа закрывает вызывающий.
+func (c *Client) send(ctx context.Context, op, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, &DeviceError{Op: op, Err: fmt.Errorf("ошибка создания HTTP запроса: %v", err)}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml; charset=UTF-8")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, &DeviceError{Op: op, Err: classifyRequestError(ctx, err)}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return nil, &DeviceError{
+			Op:         op,
+			StatusCode: resp.StatusCode,
+			Body:       string(data),
+			Err:        classifyStatus(resp.StatusCode, data),
+		}
+	}
+
+	return resp, nil
+}
+
+// classifyStatus сопоставляет HTTP статус и ответ ISAPI с ошибкой пакета
+func classifyStatus(statusCode int, body []byte) error {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrAuth
+	case statusCode == http.StatusNotFound:
+		return ErrNotFound
+	case statusCode == http.StatusServiceUnavailable || statusCode == http.StatusTooManyRequests,
+		bytes.Contains(body, []byte("deviceBusy")):
+		return ErrDeviceBusy
+	}
+
+	message := strings.TrimSpace(string(body))
+	if len(message) > 200 {
+		message = message[:200] + "..."
+	}
+	return fmt.Errorf("ошибка HTTP %d: %s", statusCode, message)
+}
+
+// classifyRequestError различает отмену, таймаут и прочие сетевые ошибки
+func classifyRequestError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return context.Canceled
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrTimeout
+	}
+	return fmt.Errorf("ошибка подключения: %v", err)
+}
+
+var (
+	clients   = make(map[string]*Client)
+	clientsMu sync.Mutex
+)
+
+// ClientFor возвращает клиент устройства, переиспользуя его между запросами.
+// Клиент пересоздается, если настройки устройства изменились.
+func ClientFor(device *config.Device) (*Client, error) {
+	if device.Vendor != config.VendorHikvision {
+		return nil, fmt.Errorf("%w: %s (%s)", ErrUnsupportedVendor, device.ID, device.Vendor)
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	client, ok := clients[device.ID]
+	if !ok || client.device != *device {
+		client = NewClient(*device)
+		clients[device.ID] = client
+	}
+	return client, nil
+}
+
+// ClientForChannel возвращает клиент устройства канала и номер канала на нем
+func ClientForChannel(channelID string) (*Client, string, error) {
+	channel, device, err := config.ResolveChannel(channelID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client, err := ClientFor(device)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, channel.DeviceChannelID(), nil
+}
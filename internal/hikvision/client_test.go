@@ -0,0 +1,241 @@
+// internal/hikvision/client_test.go
+package hikvision
+
+import (
+	"TeleOko/internal/config"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serverPort возвращает порт тестового сервера
+func serverPort(t *testing.T, server *httptest.Server) int {
+	t.Helper()
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort возвращает порт, на котором никто не слушает
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+// newISAPIServer запускает сервер вместо ISAPI регистратора
+func newISAPIServer(t *testing.T, tls bool, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+	if tls {
+		server = httptest.NewTLSServer(handler)
+	} else {
+		server = httptest.NewServer(handler)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+// deviceInfoHandler отвечает на запрос /ISAPI/System/deviceInfo и считает запросы
+func deviceInfoHandler(requests *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ISAPI/System/deviceInfo" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		w.Write([]byte("<DeviceInfo><deviceName>NVR</deviceName></DeviceInfo>"))
+	}
+}
+
+func TestISAPIUsesHTTPPort(t *testing.T) {
+	var requests atomic.Int32
+	server := newISAPIServer(t, false, deviceInfoHandler(&requests))
+
+	device := config.Device{
+		ID:        "nvr",
+		Vendor:    config.VendorHikvision,
+		IP:        "127.0.0.1",
+		RTSPPort:  closedPort(t),
+		HTTPPort:  serverPort(t, server),
+		HTTPSPort: closedPort(t),
+	}
+	client := NewClient(device)
+
+	if err := client.TestConnection(context.Background()); err != nil {
+		t.Fatalf("TestConnection: %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("запросов к ISAPI: %d", requests.Load())
+	}
+
+	// RTSP адрес архива строится по RTSP порту, а не по порту ISAPI
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	playbackURL, err := url.Parse(client.PlaybackURL(context.Background(), "101", start, start.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if playbackURL.Port() != strconv.Itoa(device.RTSPPort) {
+		t.Fatalf("RTSP URL %s, ожидался порт %d", playbackURL.Redacted(), device.RTSPPort)
+	}
+}
+
+func TestISAPIUsesHTTPSPort(t *testing.T) {
+	var requests atomic.Int32
+	server := newISAPIServer(t, true, deviceInfoHandler(&requests))
+
+	client := NewClient(config.Device{
+		ID:                 "nvr",
+		Vendor:             config.VendorHikvision,
+		IP:                 "127.0.0.1",
+		HTTPPort:           closedPort(t),
+		HTTPSPort:          serverPort(t, server),
+		UseHTTPS:           true,
+		InsecureSkipVerify: true,
+	})
+
+	if err := client.TestConnection(context.Background()); err != nil {
+		t.Fatalf("TestConnection: %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("запросов к ISAPI: %d", requests.Load())
+	}
+}
+
+func TestISAPIRejectsSelfSignedCertificate(t *testing.T) {
+	var requests atomic.Int32
+	server := newISAPIServer(t, true, deviceInfoHandler(&requests))
+
+	client := NewClient(config.Device{
+		ID:        "nvr",
+		Vendor:    config.VendorHikvision,
+		IP:        "127.0.0.1",
+		HTTPSPort: serverPort(t, server),
+		UseHTTPS:  true,
+	})
+
+	err := client.TestConnection(context.Background())
+	if err == nil {
+		t.Fatal("самоподписанный сертификат принят без insecure_skip_verify")
+	}
+	if !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("ожидалась ошибка сертификата, получено %v", err)
+	}
+	if requests.Load() != 0 {
+		t.Fatalf("запрос дошел до сервера: %d", requests.Load())
+	}
+}
+
+func TestISAPIStatusErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{status: http.StatusUnauthorized, want: ErrAuth},
+		{status: http.StatusForbidden, want: ErrAuth},
+		{status: http.StatusNotFound, want: ErrNotFound},
+		{status: http.StatusServiceUnavailable, want: ErrDeviceBusy},
+		{status: http.StatusInternalServerError, body: "<ResponseStatus><subStatusCode>deviceBusy</subStatusCode></ResponseStatus>", want: ErrDeviceBusy},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := newISAPIServer(t, false, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			client := NewClient(config.Device{
+				ID:       "nvr",
+				Vendor:   config.VendorHikvision,
+				IP:       "127.0.0.1",
+				HTTPPort: serverPort(t, server),
+			})
+
+			err := client.TestConnection(context.Background())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.want)
+			}
+
+			var deviceErr *DeviceError
+			if !errors.As(err, &deviceErr) || deviceErr.StatusCode != tt.status {
+				t.Fatalf("ожидалась DeviceError с HTTP %d, получено %#v", tt.status, err)
+			}
+		})
+	}
+}
+
+func TestISAPIUnreachable(t *testing.T) {
+	client := NewClient(config.Device{
+		ID:       "nvr",
+		Vendor:   config.VendorHikvision,
+		IP:       "127.0.0.1",
+		HTTPPort: closedPort(t),
+	})
+
+	err := client.TestConnection(context.Background())
+	var deviceErr *DeviceError
+	if !errors.As(err, &deviceErr) || deviceErr.StatusCode != 0 {
+		t.Fatalf("ожидалась сетевая DeviceError, получено %v", err)
+	}
+}
+
+func TestSearchCursorBoundToQuery(t *testing.T) {
+	var searches atomic.Int32
+	server := newISAPIServer(t, false, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ISAPI/ContentMgmt/search" {
+			http.NotFound(w, r)
+			return
+		}
+		searches.Add(1)
+		w.Write([]byte(`<CMSearchResult><responseStatusStrg>MORE</responseStatusStrg><matchList><searchMatchItem>` +
+			`<timeSpan><startTime>2024-05-01T10:00:00Z</startTime><endTime>2024-05-01T10:10:00Z</endTime></timeSpan>` +
+			`</searchMatchItem></matchList></CMSearchResult>`))
+	})
+
+	client := NewClient(config.Device{
+		ID:       "nvr",
+		Vendor:   config.VendorHikvision,
+		IP:       "127.0.0.1",
+		HTTPPort: serverPort(t, server),
+	})
+
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	query := SearchQuery{DeviceChannel: "101", StartTime: start, EndTime: start.Add(24 * time.Hour)}
+	_, cursor, err := client.SearchRecordingsPage(context.Background(), query, "", 1)
+	if err != nil || cursor == "" {
+		t.Fatalf("первая страница: курсор %q, %v", cursor, err)
+	}
+	if _, _, err := client.SearchRecordingsPage(context.Background(), query, cursor, 1); err != nil {
+		t.Fatalf("продолжение поиска: %v", err)
+	}
+
+	changed := map[string]SearchQuery{
+		"канал":     {DeviceChannel: "201", StartTime: query.StartTime, EndTime: query.EndTime},
+		"начало":    {DeviceChannel: "101", StartTime: start.Add(time.Hour), EndTime: query.EndTime},
+		"окончание": {DeviceChannel: "101", StartTime: query.StartTime, EndTime: start.Add(time.Hour)},
+		"событие":   {DeviceChannel: "101", StartTime: query.StartTime, EndTime: query.EndTime, Event: EventMotion},
+	}
+	before := searches.Load()
+	for name, other := range changed {
+		if _, _, err := client.SearchRecordingsPage(context.Background(), other, cursor, 1); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: ожидалась ErrInvalidCursor, получено %v", name, err)
+		}
+	}
+	if searches.Load() != before {
+		t.Fatal("поиск с чужим курсором дошел до устройства")
+	}
+}
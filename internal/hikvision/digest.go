@@ -0,0 +1,290 @@
+// internal/hikvision/digest.go
+package hikvision
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// authTransport выполняет HTTP Digest (RFC 7616) или Basic авторизацию
+// для ISAPI. Схема и nonce кешируются в транспорте клиента устройства,
+// поэтому последующие запросы авторизуются сразу, без лишнего ответа 401.
+type authTransport struct {
+	username string
+	password string
+	base     http.RoundTripper
+	state    *authState
+}
+
+// authState - кеш авторизации устройства
+type authState struct {
+	mu        sync.Mutex
+	basic     bool
+	challenge *digestChallenge
+	nc        uint32
+}
+
+// digestChallenge - параметры заголовка WWW-Authenticate: Digest
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+// RoundTrip выполняет запрос, при необходимости повторяя его с авторизацией
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	state := t.state
+
+	first, err := t.authorize(req, state)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(first)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Разбираем вызов устройства и повторяем запрос один раз
+	if !state.update(resp.Header.Values("WWW-Authenticate")) {
+		return resp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// Тело нельзя отправить повторно
+		return resp, nil
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	retry, err := t.authorize(req, state)
+	if err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// authorize копирует запрос и добавляет заголовок авторизации по кешу
+func (t *authTransport) authorize(req *http.Request, state *authState) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка повтора тела запроса: %v", err)
+		}
+		clone.Body = body
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	switch {
+	case state.challenge != nil:
+		state.nc++
+		header, err := state.challenge.authorization(t.username, t.password, req.Method, req.URL.RequestURI(), state.nc)
+		if err != nil {
+			return nil, err
+		}
+		clone.Header.Set("Authorization", header)
+	case state.basic:
+		clone.SetBasicAuth(t.username, t.password)
+	}
+
+	return clone, nil
+}
+
+// update выбирает схему авторизации по заголовкам WWW-Authenticate.
+// Digest предпочтительнее Basic, SHA-256 предпочтительнее MD5.
+func (s *authState) update(headers []string) bool {
+	var best *digestChallenge
+	basic := false
+
+	for _, header := range headers {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+		switch strings.ToLower(scheme) {
+		case "digest":
+			challenge := parseDigestChallenge(params)
+			if challenge == nil {
+				continue
+			}
+			if best == nil || (best.algorithm != "SHA-256" && challenge.algorithm == "SHA-256") {
+				best = challenge
+			}
+		case "basic":
+			basic = true
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case best != nil:
+		s.challenge = best
+		s.basic = false
+		s.nc = 0
+		return true
+	case basic && !s.basic:
+		s.challenge = nil
+		s.basic = true
+		return true
+	}
+	return false
+}
+
+// parseDigestChallenge разбирает параметры Digest вызова
+func parseDigestChallenge(params string) *digestChallenge {
+	values := parseAuthParams(params)
+	if values["nonce"] == "" {
+		return nil
+	}
+
+	challenge := &digestChallenge{
+		realm:     values["realm"],
+		nonce:     values["nonce"],
+		opaque:    values["opaque"],
+		algorithm: strings.ToUpper(values["algorithm"]),
+	}
+	if challenge.algorithm == "" {
+		challenge.algorithm = "MD5"
+	}
+	if _, err := digestHash(challenge.algorithm); err != nil {
+		return nil
+	}
+
+	// Поддерживаем только qop=auth; без qop используем схему RFC 2069
+	for _, qop := range strings.Split(values["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			challenge.qop = "auth"
+		}
+	}
+	if values["qop"] != "" && challenge.qop == "" {
+		return nil
+	}
+
+	return challenge
+}
+
+// authorization формирует заголовок Authorization: Digest
+func (c *digestChallenge) authorization(username, password, method, uri string, nc uint32) (string, error) {
+	newHash, err := digestHash(c.algorithm)
+	if err != nil {
+		return "", err
+	}
+	h := func(s string) string {
+		hasher := newHash()
+		hasher.Write([]byte(s))
+		return hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	cnonce, err := newCnonce()
+	if err != nil {
+		return "", err
+	}
+	ncValue := fmt.Sprintf("%08x", nc)
+
+	ha1 := h(username + ":" + c.realm + ":" + password)
+	if strings.HasSuffix(c.algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var response string
+	if c.qop != "" {
+		response = h(strings.Join([]string{ha1, c.nonce, ncValue, cnonce, c.qop, ha2}, ":"))
+	} else {
+		response = h(ha1 + ":" + c.nonce + ":" + ha2)
+	}
+
+	parts := []string{
+		fmt.Sprintf(`username="%s"`, username),
+		fmt.Sprintf(`realm="%s"`, c.realm),
+		fmt.Sprintf(`nonce="%s"`, c.nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+		fmt.Sprintf(`algorithm=%s`, c.algorithm),
+		fmt.Sprintf(`response="%s"`, response),
+	}
+	if c.qop != "" {
+		parts = append(parts, "qop="+c.qop, "nc="+ncValue, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+	if c.opaque != "" {
+		parts = append(parts, fmt.Sprintf(`opaque="%s"`, c.opaque))
+	}
+
+	return "Digest " + strings.Join(parts, ", "), nil
+}
+
+// newCnonce возвращает случайный cnonce клиента. Тесты подменяют его,
+// чтобы сверить ответ с примерами RFC 7616.
+var newCnonce = func() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// digestHash возвращает хеш-функцию для алгоритма Digest
+func digestHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.TrimSuffix(algorithm, "-SESS") {
+	case "MD5":
+		return md5.New, nil
+	case "SHA-256":
+		return sha256.New, nil
+	default:
+		return nil, fmt.Errorf("неподдерживаемый алгоритм Digest: %s", algorithm)
+	}
+}
+
+// parseAuthParams разбирает список key=value и key="value" через запятую
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,\t")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			// Значение в кавычках может содержать запятые и экранирование
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value = b.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+
+		params[key] = value
+	}
+
+	return params
+}
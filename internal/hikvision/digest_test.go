@@ -0,0 +1,291 @@
+// internal/hikvision/digest_test.go
+package hikvision
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Пример из RFC 7616, раздел 3.9.1
+const (
+	rfcUsername = "Mufasa"
+	rfcPassword = "Circle of Life"
+	rfcRealm    = "http-auth@example.org"
+	rfcNonce    = "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+	rfcOpaque   = "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"
+	rfcCnonce   = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	rfcURI      = "/dir/index.html"
+)
+
+// useRFCCnonce подменяет cnonce клиента значением из примера RFC 7616
+func useRFCCnonce(t *testing.T) {
+	previous := newCnonce
+	newCnonce = func() (string, error) { return rfcCnonce, nil }
+	t.Cleanup(func() { newCnonce = previous })
+}
+
+// authRequest - запрос, полученный устройством
+type authRequest struct {
+	authorization string
+	body          string
+}
+
+// authServer - устройство, которое отвечает 401 с вызовом challenge,
+// пока authorized не примет заголовок Authorization
+type authServer struct {
+	mu       sync.Mutex
+	requests []authRequest
+}
+
+// newAuthServer запускает устройство и возвращает клиент с авторизацией
+func newAuthServer(t *testing.T, challenge func() []string, authorized func(r *http.Request) bool) (*authServer, *http.Client, string) {
+	t.Helper()
+
+	s := &authServer{}
+	server := newISAPIServer(t, false, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, authRequest{authorization: r.Header.Get("Authorization"), body: string(body)})
+		s.mu.Unlock()
+
+		if !authorized(r) {
+			for _, header := range challenge() {
+				w.Header().Add("WWW-Authenticate", header)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("<ok/>"))
+	})
+
+	client := &http.Client{Transport: &authTransport{
+		username: rfcUsername,
+		password: rfcPassword,
+		base:     http.DefaultTransport,
+		state:    &authState{},
+	}}
+	return s, client, server.URL
+}
+
+// received возвращает запросы, полученные устройством
+func (s *authServer) received() []authRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]authRequest(nil), s.requests...)
+}
+
+// digestParams разбирает заголовок Authorization: Digest
+func digestParams(t *testing.T, header string) map[string]string {
+	t.Helper()
+	scheme, params, _ := strings.Cut(header, " ")
+	if scheme != "Digest" {
+		t.Fatalf("ожидалась авторизация Digest, получено %q", header)
+	}
+	return parseAuthParams(params)
+}
+
+// get выполняет GET и проверяет статус ответа
+func get(t *testing.T, client *http.Client, url string, status int) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("HTTP %d, ожидался %d", resp.StatusCode, status)
+	}
+}
+
+func TestDigestRFC7616(t *testing.T) {
+	useRFCCnonce(t)
+
+	tests := []struct {
+		algorithm string
+		response  string
+	}{
+		{algorithm: "MD5", response: "8ca523f5e9506fed4657c9700eebdbec"},
+		{algorithm: "SHA-256", response: "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+		{algorithm: "MD5-sess", response: "e783283f46242139c486a698fec7211d"},
+		{algorithm: "SHA-256-sess", response: "2fd51b3a77ad75bad6afad6003e818d767133c46d9e2749e7f5232ae1ea3efd7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			challenge := func() []string {
+				return []string{`Digest realm="` + rfcRealm + `", qop="auth, auth-int", algorithm=` + tt.algorithm +
+					`, nonce="` + rfcNonce + `", opaque="` + rfcOpaque + `"`}
+			}
+			server, client, baseURL := newAuthServer(t, challenge, func(r *http.Request) bool {
+				return r.Header.Get("Authorization") != ""
+			})
+
+			get(t, client, baseURL+rfcURI, http.StatusOK)
+
+			requests := server.received()
+			if len(requests) != 2 || requests[0].authorization != "" {
+				t.Fatalf("запросы к устройству: %+v", requests)
+			}
+			params := digestParams(t, requests[1].authorization)
+			want := map[string]string{
+				"username":  rfcUsername,
+				"realm":     rfcRealm,
+				"uri":       rfcURI,
+				"algorithm": strings.ToUpper(tt.algorithm),
+				"nonce":     rfcNonce,
+				"nc":        "00000001",
+				"cnonce":    rfcCnonce,
+				"qop":       "auth",
+				"opaque":    rfcOpaque,
+				"response":  tt.response,
+			}
+			for key, value := range want {
+				if params[key] != value {
+					t.Errorf("%s = %q, ожидалось %q", key, params[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestDigestNonceCount(t *testing.T) {
+	// Устройство предлагает MD5 и SHA-256, клиент выбирает SHA-256
+	challenge := func() []string {
+		return []string{
+			`Digest realm="NVR", qop="auth", algorithm=MD5, nonce="md5-nonce"`,
+			`Digest realm="NVR", qop="auth", algorithm=SHA-256, nonce="sha-nonce"`,
+		}
+	}
+	server, client, baseURL := newAuthServer(t, challenge, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") != ""
+	})
+
+	for i := 0; i < 3; i++ {
+		get(t, client, baseURL+"/ISAPI/System/deviceInfo", http.StatusOK)
+	}
+
+	// Вызов устройства кешируется: 401 только на первый запрос
+	requests := server.received()
+	if len(requests) != 4 || requests[0].authorization != "" {
+		t.Fatalf("запросы к устройству: %+v", requests)
+	}
+	for i, nc := range []string{"00000001", "00000002", "00000003"} {
+		params := digestParams(t, requests[i+1].authorization)
+		if params["algorithm"] != "SHA-256" || params["nonce"] != "sha-nonce" || params["nc"] != nc {
+			t.Errorf("запрос %d: algorithm=%s nonce=%s nc=%s, ожидался nc=%s", i+1,
+				params["algorithm"], params["nonce"], params["nc"], nc)
+		}
+	}
+}
+
+func TestDigestStaleNonce(t *testing.T) {
+	var mu sync.Mutex
+	nonce := "first"
+	challenge := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return []string{`Digest realm="NVR", qop="auth", nonce="` + nonce + `", stale=true`}
+	}
+	server, client, baseURL := newAuthServer(t, challenge, func(r *http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		_, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		return parseAuthParams(params)["nonce"] == nonce
+	})
+
+	get(t, client, baseURL+"/ISAPI/System/deviceInfo", http.StatusOK)
+
+	// Устройство сменило nonce: клиент повторяет запрос с новым
+	mu.Lock()
+	nonce = "second"
+	mu.Unlock()
+	get(t, client, baseURL+"/ISAPI/System/deviceInfo", http.StatusOK)
+
+	requests := server.received()
+	if len(requests) != 4 {
+		t.Fatalf("запросов к устройству: %d", len(requests))
+	}
+	want := []struct{ nonce, nc string }{{"first", "00000001"}, {"first", "00000002"}, {"second", "00000001"}}
+	for i, w := range want {
+		params := digestParams(t, requests[i+1].authorization)
+		if params["nonce"] != w.nonce || params["nc"] != w.nc {
+			t.Errorf("запрос %d: nonce=%s nc=%s, ожидалось nonce=%s nc=%s", i+1, params["nonce"], params["nc"], w.nonce, w.nc)
+		}
+	}
+}
+
+func TestBasicFallback(t *testing.T) {
+	challenge := func() []string { return []string{`Basic realm="NVR"`} }
+	server, client, baseURL := newAuthServer(t, challenge, func(r *http.Request) bool {
+		username, password, ok := r.BasicAuth()
+		return ok && username == rfcUsername && password == rfcPassword
+	})
+
+	get(t, client, baseURL+"/ISAPI/System/deviceInfo", http.StatusOK)
+	get(t, client, baseURL+"/ISAPI/System/deviceInfo", http.StatusOK)
+
+	// Второй запрос сразу отправляется с Basic
+	requests := server.received()
+	if len(requests) != 3 || requests[0].authorization != "" {
+		t.Fatalf("запросы к устройству: %+v", requests)
+	}
+	for _, r := range requests[1:] {
+		if !strings.HasPrefix(r.authorization, "Basic ") {
+			t.Errorf("ожидалась авторизация Basic, получено %q", r.authorization)
+		}
+	}
+}
+
+func TestDigestReplaysBodyWithGetBody(t *testing.T) {
+	challenge := func() []string { return []string{`Digest realm="NVR", qop="auth", nonce="nonce"`} }
+	authorized := func(r *http.Request) bool { return r.Header.Get("Authorization") != "" }
+	const body = "<CMSearchDescription/>"
+
+	t.Run("с GetBody", func(t *testing.T) {
+		server, client, baseURL := newAuthServer(t, challenge, authorized)
+
+		req, err := http.NewRequest(http.MethodPost, baseURL+"/ISAPI/ContentMgmt/search", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("HTTP %d", resp.StatusCode)
+		}
+
+		requests := server.received()
+		if len(requests) != 2 || requests[0].body != body || requests[1].body != body {
+			t.Fatalf("запросы к устройству: %+v", requests)
+		}
+	})
+
+	t.Run("без GetBody", func(t *testing.T) {
+		server, client, baseURL := newAuthServer(t, challenge, authorized)
+
+		req, err := http.NewRequest(http.MethodPost, baseURL+"/ISAPI/ContentMgmt/search", io.NopCloser(strings.NewReader(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		// Тело уже прочитано, поэтому запрос не повторяется
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("HTTP %d, ожидался 401", resp.StatusCode)
+		}
+		if requests := server.received(); len(requests) != 1 {
+			t.Fatalf("запросов к устройству: %d", len(requests))
+		}
+	})
+}
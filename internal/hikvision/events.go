@@ -0,0 +1,426 @@
+// internal/hikvision/events.go
+package hikvision
+
+import (
+	"TeleOko/internal/config"
+	"TeleOko/internal/timeutil"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Параметры подписки на события устройства
+const (
+	alertStreamPath = "/ISAPI/Event/notification/alertStream"
+	// eventRetryMin и eventRetryMax - пределы паузы между переподключениями
+	eventRetryMin = time.Second
+	eventRetryMax = time.Minute
+	// maxEventPartSize - ограничение размера одной части multipart
+	maxEventPartSize = 1 << 20
+)
+
+// eventIdleTimeout - сколько ждать данных (в т.ч. heartbeat) до переподключения
+var eventIdleTimeout = 60 * time.Second
+
+// Дополнительные типы событий, которые приходят только в alertStream
+const (
+	EventTamper    = "tamper"    // закрытие объектива
+	EventVideoLoss = "videoloss" // потеря видеосигнала
+	EventDiskFull  = "diskfull"  // диск заполнен
+	EventDiskError = "diskerror" // ошибка диска
+)
+
+// EventDeviceOffline - устройство не отвечает (active) или снова доступно (inactive)
+const EventDeviceOffline = "deviceoffline"
+
+// alertTypes - типы событий ISAPI и их названия в TeleOko
+var alertTypes = map[string]string{
+	"vmd":             EventMotion,
+	"linedetection":   EventLineDetection,
+	"fielddetection":  EventFieldDetection,
+	"io":              EventAlarm,
+	"tamperdetection": EventTamper,
+	"shelteralarm":    EventTamper,
+	"videoloss":       EventVideoLoss,
+	"diskfull":        EventDiskFull,
+	"diskerror":       EventDiskError,
+}
+
+// Состояния события
+const (
+	EventStateActive   = "active"
+	EventStateInactive = "inactive"
+)
+
+// Event - событие устройства из alertStream
+type Event struct {
+	ID            string    `json:"id"`
+	Device        string    `json:"device"`
+	Channel       string    `json:"channel,omitempty"`        // канал TeleOko
+	DeviceChannel string    `json:"device_channel,omitempty"` // номер канала в событии устройства
+	Type          string    `json:"type"`
+	RawType       string    `json:"raw_type"`
+	State         string    `json:"state"`
+	Description   string    `json:"description,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// eventNotificationAlert - событие ISAPI (XML или JSON в зависимости от прошивки)
+type eventNotificationAlert struct {
+	XMLName          xml.Name `xml:"EventNotificationAlert" json:"-"`
+	ChannelID        string   `xml:"channelID" json:"channelID"`
+	DynChannelID     string   `xml:"dynChannelID" json:"dynChannelID"`
+	InputIOPortID    string   `xml:"inputIOPortID" json:"inputIOPortID"`
+	DateTime         string   `xml:"dateTime" json:"dateTime"`
+	EventType        string   `xml:"eventType" json:"eventType"`
+	EventState       string   `xml:"eventState" json:"eventState"`
+	EventDescription string   `xml:"eventDescription" json:"eventDescription"`
+}
+
+// errEventIdle - устройство перестало присылать данные
+var errEventIdle = errors.New("нет данных от устройства")
+
+// StreamEvents подключается к alertStream устройства и передает события
+// в handle, пока соединение не прервется или не будет отменен ctx.
+// Heartbeat сообщения (videoloss inactive) в handle не передаются.
+func (c *Client) StreamEvents(ctx context.Context, handle func(Event)) error {
+	return c.streamEvents(ctx, handle, nil)
+}
+
+// streamEvents - StreamEvents с уведомлением об успешном подключении
+func (c *Client) streamEvents(ctx context.Context, handle func(Event), connected func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Сторожевой таймер: без данных соединение считается зависшим
+	var idle atomic.Bool
+	watchdog := time.AfterFunc(eventIdleTimeout, func() {
+		idle.Store(true)
+		cancel()
+	})
+	defer watchdog.Stop()
+
+	resp, err := c.send(ctx, "подписка на события", http.MethodGet, alertStreamPath, nil)
+	if err != nil {
+		if idle.Load() {
+			return fmt.Errorf("%w %s", errEventIdle, eventIdleTimeout)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return fmt.Errorf("подписка на события: неверный Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+
+	if connected != nil {
+		connected()
+	}
+
+	loc := c.Location(ctx)
+	reader := newPartReader(resp.Body, params["boundary"])
+	for {
+		header, data, err := reader.next()
+		if err != nil {
+			switch {
+			case idle.Load():
+				return fmt.Errorf("%w %s", errEventIdle, eventIdleTimeout)
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case errors.Is(err, io.EOF):
+				return fmt.Errorf("устройство закрыло поток событий")
+			}
+			return fmt.Errorf("ошибка чтения потока событий: %v", err)
+		}
+		watchdog.Reset(eventIdleTimeout)
+
+		event, ok, err := c.parseEventPart(header, data, loc)
+		if err != nil {
+			log.Printf("⚠️ Устройство %s: %v", c.device.ID, err)
+			continue
+		}
+		if ok {
+			handle(event)
+		}
+	}
+}
+
+// parseEventPart разбирает часть multipart. Картинки и heartbeat
+// пропускаются (ok = false).
+func (c *Client) parseEventPart(header textproto.MIMEHeader, data []byte, loc *time.Location) (Event, bool, error) {
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	if strings.HasPrefix(contentType, "image/") {
+		return Event{}, false, nil
+	}
+
+	var alert eventNotificationAlert
+	var err error
+	if strings.Contains(contentType, "json") {
+		err = json.Unmarshal(data, &alert)
+	} else {
+		err = xml.Unmarshal(data, &alert)
+	}
+	if err != nil {
+		return Event{}, false, fmt.Errorf("ошибка разбора события: %v", err)
+	}
+
+	event := c.newEvent(alert, loc)
+	if event.Type == EventVideoLoss && event.State == EventStateInactive {
+		return Event{}, false, nil
+	}
+	return event, true, nil
+}
+
+// partReader читает части multipart потока alertStream. В отличие от
+// mime/multipart учитывает Content-Length, поэтому событие передается сразу,
+// не дожидаясь следующей границы, которая придет только со следующим событием.
+type partReader struct {
+	r        *bufio.Reader
+	boundary string
+	atPart   bool // граница уже прочитана, следующими идут заголовки части
+	closed   bool // прочитана завершающая граница
+}
+
+// newPartReader создает читатель частей с указанной границей
+func newPartReader(r io.Reader, boundary string) *partReader {
+	return &partReader{
+		r:        bufio.NewReader(r),
+		boundary: "--" + strings.TrimPrefix(boundary, "--"),
+	}
+}
+
+// next возвращает заголовки и тело следующей части
+func (p *partReader) next() (textproto.MIMEHeader, []byte, error) {
+	if p.closed {
+		return nil, nil, io.EOF
+	}
+	if !p.atPart {
+		if err := p.skipToBoundary(); err != nil {
+			return nil, nil, err
+		}
+	}
+	p.atPart = false
+
+	header, err := textproto.NewReader(p.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if length := header.Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil || n < 0 || n > maxEventPartSize {
+			return nil, nil, fmt.Errorf("неверный Content-Length части: %q", length)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(p.r, data); err != nil {
+			return nil, nil, err
+		}
+		return header, data, nil
+	}
+
+	// Без Content-Length тело заканчивается на следующей границе
+	var data []byte
+	for {
+		line, err := p.r.ReadString('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.isBoundary(line) || p.isClosing(line) {
+			p.atPart = p.isBoundary(line)
+			p.closed = !p.atPart
+			return header, bytes.TrimRight(data, "\r\n"), nil
+		}
+		if len(data)+len(line) > maxEventPartSize {
+			return nil, nil, fmt.Errorf("часть больше %d байт", maxEventPartSize)
+		}
+		data = append(data, line...)
+	}
+}
+
+// skipToBoundary пропускает данные до начала следующей части
+func (p *partReader) skipToBoundary() error {
+	for {
+		line, err := p.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if p.isClosing(line) {
+			p.closed = true
+			return io.EOF
+		}
+		if p.isBoundary(line) {
+			return nil
+		}
+	}
+}
+
+// isBoundary проверяет, является ли строка границей части
+func (p *partReader) isBoundary(line string) bool {
+	return strings.TrimSpace(line) == p.boundary
+}
+
+// isClosing проверяет, является ли строка завершающей границей потока
+func (p *partReader) isClosing(line string) bool {
+	return strings.TrimSpace(line) == p.boundary+"--"
+}
+
+// newEvent преобразует событие ISAPI в событие TeleOko
+func (c *Client) newEvent(alert eventNotificationAlert, loc *time.Location) Event {
+	rawType := strings.TrimSpace(alert.EventType)
+	eventType, ok := alertTypes[strings.ToLower(rawType)]
+	if !ok {
+		eventType = strings.ToLower(rawType)
+	}
+
+	deviceChannel := alert.ChannelID
+	if deviceChannel == "" {
+		deviceChannel = alert.DynChannelID
+	}
+
+	eventTime, err := timeutil.ParseISAPI(alert.DateTime, loc)
+	if err != nil {
+		eventTime = time.Now()
+	}
+
+	state := strings.ToLower(strings.TrimSpace(alert.EventState))
+	if state == "" {
+		state = EventStateActive
+	}
+
+	return Event{
+		ID:            uuid.New().String(),
+		Device:        c.device.ID,
+		Channel:       channelForEvent(c.device.ID, deviceChannel),
+		DeviceChannel: deviceChannel,
+		Type:          eventType,
+		RawType:       rawType,
+		State:         state,
+		Description:   strings.TrimSpace(alert.EventDescription),
+		Time:          eventTime,
+	}
+}
+
+// channelForEvent находит канал TeleOko по номеру канала в событии.
+// В событиях указывается номер камеры (2), а в каналах - номер потока
+// (201 - основной, 202 - дополнительный); предпочитается основной поток.
+func channelForEvent(deviceID, deviceChannel string) string {
+	n, err := strconv.Atoi(deviceChannel)
+	if err != nil || n <= 0 {
+		return ""
+	}
+
+	candidates := []string{strconv.Itoa(n*100 + 1), strconv.Itoa(n*100 + 2), strconv.Itoa(n)}
+	channels := config.GetChannels()
+	for _, candidate := range candidates {
+		for _, channel := range channels {
+			if channel.Device == deviceID && channel.DeviceChannelID() == candidate {
+				return channel.ID
+			}
+		}
+	}
+	return ""
+}
+
+// RunEventSubscriber держит подписку на события устройства и
+// переподключается при обрыве, пока не будет отменен ctx. Если устройство
+// перестает отвечать, передается событие EventDeviceOffline (active),
+// а после восстановления связи - то же событие в состоянии inactive.
+func RunEventSubscriber(ctx context.Context, deviceID string, handle func(Event)) {
+	delay := eventRetryMin
+	offline := false
+	for {
+		started := time.Now()
+		connected := false
+		err := subscribeOnce(ctx, deviceID, handle, func() {
+			connected = true
+			if offline {
+				offline = false
+				log.Printf("✅ Устройство %s снова доступно", deviceID)
+				handle(deviceStatusEvent(deviceID, EventStateInactive, "связь восстановлена"))
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !offline && isUnreachable(err, connected) {
+			offline = true
+			log.Printf("🔌 Устройство %s недоступно: %v", deviceID, err)
+			handle(deviceStatusEvent(deviceID, EventStateActive, err.Error()))
+		}
+
+		// После долго работавшего соединения переподключаемся быстро
+		if time.Since(started) > eventIdleTimeout {
+			delay = eventRetryMin
+		}
+		log.Printf("⚠️ Поток событий устройства %s прерван: %v, переподключение через %s", deviceID, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > eventRetryMax {
+			delay = eventRetryMax
+		}
+	}
+}
+
+// subscribeOnce выполняет одно подключение к alertStream. Клиент берется
+// заново, чтобы учитывать изменения настроек устройства.
+func subscribeOnce(ctx context.Context, deviceID string, handle func(Event), connected func()) error {
+	device := config.GetDevice(deviceID)
+	if device == nil {
+		return fmt.Errorf("устройство %s не найдено", deviceID)
+	}
+
+	client, err := ClientFor(device)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("📡 Подписка на события устройства %s", deviceID)
+	return client.streamEvents(ctx, handle, connected)
+}
+
+// isUnreachable проверяет, что устройство не ответило по сети (ответ
+// с HTTP ошибкой означает, что устройство работает) или перестало присылать
+// данные, включая heartbeat, после подключения
+func isUnreachable(err error, connected bool) bool {
+	if errors.Is(err, errEventIdle) {
+		return true
+	}
+	var deviceErr *DeviceError
+	return !connected && errors.As(err, &deviceErr) && deviceErr.StatusCode == 0
+}
+
+// deviceStatusEvent создает событие доступности устройства
+func deviceStatusEvent(deviceID, state, description string) Event {
+	return Event{
+		ID:          uuid.New().String(),
+		Device:      deviceID,
+		Type:        EventDeviceOffline,
+		RawType:     EventDeviceOffline,
+		State:       state,
+		Description: description,
+		Time:        time.Now(),
+	}
+}
@@ -0,0 +1,273 @@
+// internal/hikvision/events_test.go
+package hikvision
+
+import (
+	"TeleOko/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testBoundary = "boundary"
+
+// alertXML возвращает событие ISAPI в XML
+func alertXML(eventType, state string) string {
+	return fmt.Sprintf(`<EventNotificationAlert><channelID>1</channelID><dateTime>2024-05-01T10:00:00+03:00</dateTime>`+
+		`<eventType>%s</eventType><eventState>%s</eventState><eventDescription>%s</eventDescription></EventNotificationAlert>`,
+		eventType, state, eventType)
+}
+
+// writePart отправляет часть multipart и сбрасывает буфер ответа
+func writePart(w http.ResponseWriter, contentType, body string, withLength bool) {
+	fmt.Fprintf(w, "--%s\r\nContent-Type: %s\r\n", testBoundary, contentType)
+	if withLength {
+		fmt.Fprintf(w, "Content-Length: %d\r\n", len(body))
+	}
+	fmt.Fprintf(w, "\r\n%s\r\n", body)
+	w.(http.Flusher).Flush()
+}
+
+// newAlertStream запускает сервер вместо alertStream регистратора
+func newAlertStream(t *testing.T, stream func(w http.ResponseWriter, r *http.Request)) *Client {
+	t.Helper()
+
+	server := newISAPIServer(t, false, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != alertStreamPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+testBoundary)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		stream(w, r)
+	})
+
+	return NewClient(config.Device{
+		ID:       "nvr",
+		Vendor:   config.VendorHikvision,
+		IP:       "127.0.0.1",
+		HTTPPort: serverPort(t, server),
+	})
+}
+
+// setIdleTimeout уменьшает сторожевой таймер alertStream на время теста
+func setIdleTimeout(t *testing.T, timeout time.Duration) {
+	previous := eventIdleTimeout
+	eventIdleTimeout = timeout
+	t.Cleanup(func() { eventIdleTimeout = previous })
+}
+
+func TestStreamEvents(t *testing.T) {
+	client := newAlertStream(t, func(w http.ResponseWriter, r *http.Request) {
+		writePart(w, "application/xml", alertXML("VMD", "active"), true)
+		// Heartbeat и картинки пропускаются
+		writePart(w, "application/xml", alertXML("videoloss", "inactive"), true)
+		writePart(w, "image/jpeg", "\xff\xd8jpeg", true)
+		writePart(w, "application/json", `{"channelID": "2", "eventType": "linedetection", "eventState": "inactive"}`, false)
+		fmt.Fprintf(w, "--%s--\r\n", testBoundary)
+	})
+
+	var events []Event
+	err := client.StreamEvents(context.Background(), func(event Event) {
+		events = append(events, event)
+	})
+	if err == nil || !strings.Contains(err.Error(), "закрыло") {
+		t.Fatalf("ожидалось закрытие потока устройством, получено %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("получено событий: %d (%+v)", len(events), events)
+	}
+	if events[0].Type != EventMotion || events[0].State != EventStateActive || events[0].DeviceChannel != "1" {
+		t.Errorf("первое событие %+v", events[0])
+	}
+	if want := time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC); !events[0].Time.Equal(want) {
+		t.Errorf("время события %s, ожидалось %s", events[0].Time, want)
+	}
+	if events[1].Type != EventLineDetection || events[1].State != EventStateInactive || events[1].DeviceChannel != "2" {
+		t.Errorf("второе событие %+v", events[1])
+	}
+}
+
+func TestStreamEventsDeliversWithoutNextBoundary(t *testing.T) {
+	release := make(chan struct{})
+	client := newAlertStream(t, func(w http.ResponseWriter, r *http.Request) {
+		writePart(w, "application/xml", alertXML("VMD", "active"), true)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan Event, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.StreamEvents(ctx, func(event Event) { received <- event })
+	}()
+
+	// Событие с Content-Length передается до следующей границы
+	select {
+	case event := <-received:
+		if event.Type != EventMotion {
+			t.Fatalf("событие %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("событие не передано до следующей границы")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("ожидалась отмена, получено %v", err)
+	}
+}
+
+func TestStreamEventsIdleTimeout(t *testing.T) {
+	setIdleTimeout(t, 200*time.Millisecond)
+	client := newAlertStream(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	err := client.StreamEvents(context.Background(), func(Event) {})
+	if !errors.Is(err, errEventIdle) {
+		t.Fatalf("ожидалась errEventIdle, получено %v", err)
+	}
+	if !isUnreachable(err, true) {
+		t.Fatal("замолчавшее устройство не считается недоступным")
+	}
+}
+
+func TestIsUnreachable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		connected bool
+		want      bool
+	}{
+		{name: "сетевая ошибка", err: &DeviceError{Err: ErrTimeout}, want: true},
+		{name: "HTTP ошибка", err: &DeviceError{StatusCode: http.StatusUnauthorized, Err: ErrAuth}},
+		{name: "обрыв после подключения", err: &DeviceError{Err: io.ErrUnexpectedEOF}, connected: true},
+		{name: "нет данных после подключения", err: fmt.Errorf("%w 60s", errEventIdle), connected: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUnreachable(tt.err, tt.connected); got != tt.want {
+				t.Fatalf("isUnreachable = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunEventSubscriberReportsSilentDevice(t *testing.T) {
+	setIdleTimeout(t, 200*time.Millisecond)
+
+	var connections atomic.Int32
+	client := newAlertStream(t, func(w http.ResponseWriter, r *http.Request) {
+		if connections.Add(1) == 1 {
+			// Первое подключение: устройство замолкает
+			<-r.Context().Done()
+			return
+		}
+		writePart(w, "application/xml", alertXML("VMD", "active"), true)
+		<-r.Context().Done()
+	})
+
+	previous := config.GlobalConfig.Devices
+	config.GlobalConfig.Devices = []config.Device{client.Device()}
+	t.Cleanup(func() { config.GlobalConfig.Devices = previous })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	var events []Event
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		RunEventSubscriber(ctx, "nvr", func(event Event) {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		n := len(events)
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("получено событий: %d", n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if events[0].Type != EventDeviceOffline || events[0].State != EventStateActive {
+		t.Errorf("первое событие %+v, ожидалась недоступность устройства", events[0])
+	}
+	if events[1].Type != EventDeviceOffline || events[1].State != EventStateInactive {
+		t.Errorf("второе событие %+v, ожидалось восстановление связи", events[1])
+	}
+	if events[2].Type != EventMotion {
+		t.Errorf("третье событие %+v", events[2])
+	}
+}
+
+func TestPartReader(t *testing.T) {
+	stream := "preamble\r\n" +
+		"--" + testBoundary + "\r\nContent-Type: application/xml\r\nContent-Length: 5\r\n\r\nfirst\r\n" +
+		"--" + testBoundary + "\r\nContent-Type: text/plain\r\n\r\nsecond\r\nline\r\n" +
+		"--" + testBoundary + "\r\nContent-Type: text/plain\r\n\r\nthird\r\n" +
+		"--" + testBoundary + "--\r\n"
+	reader := newPartReader(strings.NewReader(stream), testBoundary)
+
+	want := []struct{ contentType, body string }{
+		{"application/xml", "first"},
+		{"text/plain", "second\r\nline"},
+		{"text/plain", "third"},
+	}
+	for i, w := range want {
+		header, data, err := reader.next()
+		if err != nil {
+			t.Fatalf("часть %d: %v", i, err)
+		}
+		if header.Get("Content-Type") != w.contentType || string(data) != w.body {
+			t.Fatalf("часть %d: %q %q", i, header.Get("Content-Type"), data)
+		}
+	}
+
+	if _, _, err := reader.next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("ожидался конец потока, получено %v", err)
+	}
+}
+
+func TestPartReaderRejectsLargePart(t *testing.T) {
+	stream := "--" + testBoundary + "\r\nContent-Length: " + fmt.Sprint(maxEventPartSize+1) + "\r\n\r\n"
+	if _, _, err := newPartReader(strings.NewReader(stream), testBoundary).next(); err == nil {
+		t.Fatal("ожидалась ошибка для слишком большой части")
+	}
+
+	body := strings.Repeat("x", 1024) + "\r\n"
+	stream = "--" + testBoundary + "\r\n\r\n" + strings.Repeat(body, maxEventPartSize/len(body)+1)
+	if _, _, err := newPartReader(strings.NewReader(stream), testBoundary).next(); err == nil {
+		t.Fatal("ожидалась ошибка для слишком большой части без Content-Length")
+	}
+}
@@ -1,7 +1,10 @@
 // internal/hikvision/models.go
 package hikvision
 
-import "encoding/xml"
+import (
+	"encoding/xml"
+	"time"
+)
 
 // PlaybackSearchRequest - структура для поиска записей
 type PlaybackSearchRequest struct {
@@ -13,18 +16,92 @@ type PlaybackSearchRequest struct {
 	StartTime            string   `xml:"timeSpanList>timeSpan>startTime"`
 	EndTime              string   `xml:"timeSpanList>timeSpan>endTime"`
 	Channels             string   `xml:"channelList>channelId"`
+
+	// Metadata ограничивает поиск записями по событиям
+	Metadata *MetadataList `xml:"metadataList,omitempty"`
+}
+
+// MetadataList - список дескрипторов событий для поиска
+type MetadataList struct {
+	Descriptors []string `xml:"metadataDescriptor"`
+}
+
+// SearchQuery - параметры поиска записей на устройстве
+type SearchQuery struct {
+	DeviceChannel string
+	StartTime     time.Time
+	EndTime       time.Time
+	Event         string // тип события (EventMotion и т.д.), пусто - все записи
+}
+
+// Типы событий для фильтра поиска
+const (
+	EventMotion         = "motion"         // детекция движения (VMD)
+	EventLineDetection  = "linedetection"  // пересечение линии
+	EventFieldDetection = "fielddetection" // вторжение в зону
+	EventAlarm          = "alarm"          // тревожный вход
+)
+
+// eventDescriptors - дескрипторы metadataList для типов событий
+var eventDescriptors = map[string]string{
+	EventMotion:         "//recordType.meta.std-cgi.com/VMD",
+	EventLineDetection:  "//recordType.meta.std-cgi.com/lineDetection",
+	EventFieldDetection: "//recordType.meta.std-cgi.com/fieldDetection",
+	EventAlarm:          "//recordType.meta.std-cgi.com/AlarmInput",
+}
+
+// eventAliases - альтернативные названия типов событий
+var eventAliases = map[string]string{
+	"vmd":        EventMotion,
+	"alarminput": EventAlarm,
 }
 
 // Recording - структура для хранения информации о записи
 type Recording struct {
-	StartTime string `xml:"timeSpan>startTime" json:"StartTime"`
-	EndTime   string `xml:"timeSpan>endTime" json:"EndTime"`
-	Channel   string `xml:"trackID" json:"Channel"`
+	StartTime string `json:"StartTime"` // RFC 3339 со смещением часового пояса устройства
+	EndTime   string `json:"EndTime"`
+	Channel   string `json:"Channel"`
+
+	// PlaybackURI - RTSP адрес записи, выданный устройством (без учетных данных)
+	PlaybackURI string `json:"PlaybackURI,omitempty"`
+	SourceID    string `json:"SourceID,omitempty"`
+	TrackID     string `json:"TrackID,omitempty"`
+	Size        int64  `json:"Size,omitempty"` // размер файла в байтах, если известен
+	RecordType  string `json:"RecordType,omitempty"`
 }
 
+// Типы записей
+const (
+	RecordTypeContinuous = "continuous" // запись по расписанию
+	RecordTypeMotion     = "motion"     // детекция движения
+	RecordTypeAlarm      = "alarm"      // тревожный вход
+	RecordTypeEvent      = "event"      // интеллектуальные события и прочие триггеры
+)
+
+// searchMatchItem - запись в ответе ISAPI поиска
+type searchMatchItem struct {
+	SourceID    string `xml:"sourceID"`
+	TrackID     string `xml:"trackID"`
+	StartTime   string `xml:"timeSpan>startTime"`
+	EndTime     string `xml:"timeSpan>endTime"`
+	PlaybackURI string `xml:"mediaSegmentDescriptor>playbackURI"`
+	FileSize    int64  `xml:"mediaSegmentDescriptor>fileSize"`
+	Metadata    string `xml:"metadataMatches>metadataDescriptor"`
+}
+
+// Значения responseStatusStrg ответа на поиск
+const (
+	SearchStatusOK        = "OK"         // возвращены последние результаты
+	SearchStatusMore      = "MORE"       // есть следующие страницы
+	SearchStatusNoMatches = "NO MATCHES" // записей не найдено
+)
+
 // SearchResponse - структура для ответа на поиск записей
 type SearchResponse struct {
-	MatchList struct {
-		Recordings []Recording `xml:"searchMatchItem"`
+	SearchID           string `xml:"searchID"`
+	ResponseStatusStrg string `xml:"responseStatusStrg"`
+	NumOfMatches       int    `xml:"numOfMatches"`
+	MatchList          struct {
+		Items []searchMatchItem `xml:"searchMatchItem"`
 	} `xml:"matchList"`
 }
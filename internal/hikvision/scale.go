@@ -0,0 +1,220 @@
+// internal/hikvision/scale.go
+package hikvision
+
+import (
+	"TeleOko/internal/redact"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scaleDialTimeout - время подключения прокси к RTSP серверу регистратора
+const scaleDialTimeout = 5 * time.Second
+
+// ScaleProxy - локальный RTSP прокси к регистратору, который добавляет
+// заголовок Scale в запросы PLAY. go2rtc не запрашивает ускоренное
+// воспроизведение, а регистратор Hikvision отдает архив с нужной
+// скоростью сам, если получил Scale.
+type ScaleProxy struct {
+	listener net.Listener
+	target   string // host:port RTSP сервера регистратора
+	scale    int
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// NewScaleProxy запускает прокси для RTSP URL архива и возвращает URL,
+// по которому go2rtc получит архив со скоростью scale
+func NewScaleProxy(playbackURL string, scale int) (*ScaleProxy, string, error) {
+	u, err := url.Parse(playbackURL)
+	if err != nil || !strings.EqualFold(u.Scheme, "rtsp") {
+		return nil, "", fmt.Errorf("%w: %s", ErrInvalidPlaybackURI, redact.URL(playbackURL))
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка запуска RTSP прокси: %v", err)
+	}
+
+	p := &ScaleProxy{
+		listener: listener,
+		target:   u.Host,
+		scale:    scale,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go p.serve()
+
+	// Путь и учетные данные не меняются: регистратор не проверяет адрес
+	// в строке запроса, а digest авторизация проходит через прокси как есть
+	local := *u
+	local.Host = listener.Addr().String()
+	return p, local.String(), nil
+}
+
+// Close останавливает прокси и разрывает все соединения
+func (p *ScaleProxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	return p.listener.Close()
+}
+
+// serve принимает подключения go2rtc
+func (p *ScaleProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+// handle связывает подключение go2rtc с RTSP сервером регистратора
+func (p *ScaleProxy) handle(client net.Conn) {
+	server, err := net.DialTimeout("tcp", p.target, scaleDialTimeout)
+	if err != nil {
+		log.Printf("⚠️ RTSP прокси: регистратор %s недоступен: %v", p.target, err)
+		client.Close()
+		return
+	}
+
+	if !p.track(client, server) {
+		client.Close()
+		server.Close()
+		return
+	}
+	defer p.untrack(client, server)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(client, server)
+		done <- struct{}{}
+	}()
+	go func() {
+		p.forwardRequests(server, bufio.NewReader(client))
+		done <- struct{}{}
+	}()
+
+	// Соединение закрывается целиком, как только одна из сторон отключилась
+	<-done
+	client.Close()
+	server.Close()
+	<-done
+}
+
+// track регистрирует соединения; false, если прокси уже закрыт
+func (p *ScaleProxy) track(conns ...net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+	for _, conn := range conns {
+		p.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+// untrack снимает соединения с учета
+func (p *ScaleProxy) untrack(conns ...net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, conn := range conns {
+		delete(p.conns, conn)
+	}
+}
+
+// forwardRequests передает запросы go2rtc регистратору, добавляя Scale
+// в PLAY. Между запросами идут interleaved RTCP пакеты ($, канал, длина),
+// они передаются без изменений.
+func (p *ScaleProxy) forwardRequests(dst io.Writer, src *bufio.Reader) error {
+	for {
+		first, err := src.Peek(1)
+		if err != nil {
+			return err
+		}
+
+		if first[0] == '$' {
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(src, header); err != nil {
+				return err
+			}
+			if _, err := dst.Write(header); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(dst, src, int64(binary.BigEndian.Uint16(header[2:]))); err != nil {
+				return err
+			}
+			continue
+		}
+
+		request, contentLength, err := p.readRequest(src)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(request); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, src, contentLength); err != nil {
+			return err
+		}
+	}
+}
+
+// readRequest читает строку запроса и заголовки RTSP. Для PLAY заголовок
+// Scale заменяется скоростью прокси.
+func (p *ScaleProxy) readRequest(src *bufio.Reader) ([]byte, int64, error) {
+	var buf bytes.Buffer
+	var method string
+	var contentLength int64
+
+	for {
+		line, err := src.ReadString('\n')
+		if err != nil {
+			return nil, 0, err
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+
+		if method == "" {
+			method, _, _ = strings.Cut(trimmed, " ")
+			buf.WriteString(line)
+			continue
+		}
+
+		if trimmed == "" {
+			if method == "PLAY" {
+				fmt.Fprintf(&buf, "Scale: %d.0\r\n", p.scale)
+			}
+			buf.WriteString(line)
+			return buf.Bytes(), contentLength, nil
+		}
+
+		name, value, _ := strings.Cut(trimmed, ":")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "scale":
+			if method == "PLAY" {
+				continue
+			}
+		case "content-length":
+			contentLength, _ = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		}
+		buf.WriteString(line)
+	}
+}
@@ -0,0 +1,211 @@
+// internal/hikvision/scale_test.go
+package hikvision
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rtspRequest - запрос, полученный RTSP сервером регистратора
+type rtspRequest struct {
+	method  string
+	headers []string
+}
+
+// newFakeRTSP запускает RTSP сервер вместо регистратора. На каждый запрос
+// он отвечает 200 OK, а после PLAY отправляет interleaved пакет.
+func newFakeRTSP(t *testing.T) (string, <-chan rtspRequest, <-chan []byte) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	requests := make(chan rtspRequest, 10)
+	frames := make(chan []byte, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		for {
+			first, err := reader.Peek(1)
+			if err != nil {
+				return
+			}
+			if first[0] == '$' {
+				header := make([]byte, 4)
+				io.ReadFull(reader, header)
+				payload := make([]byte, int(header[2])<<8|int(header[3]))
+				io.ReadFull(reader, payload)
+				frames <- payload
+				continue
+			}
+
+			var req rtspRequest
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimRight(line, "\r\n")
+				if line == "" {
+					break
+				}
+				if req.method == "" {
+					req.method, _, _ = strings.Cut(line, " ")
+					continue
+				}
+				req.headers = append(req.headers, line)
+			}
+			requests <- req
+
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %d\r\n\r\n", len(requests))
+			if req.method == "PLAY" {
+				conn.Write([]byte{'$', 0, 0, 4, 'r', 't', 'p', '!'})
+			}
+		}
+	}()
+
+	return listener.Addr().String(), requests, frames
+}
+
+// readResponse читает ответ RTSP без тела
+func readResponse(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	var buf strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ошибка чтения ответа: %v", err)
+		}
+		buf.WriteString(line)
+		if line == "\r\n" {
+			return buf.String()
+		}
+	}
+}
+
+func TestScaleProxyAddsScaleToPlay(t *testing.T) {
+	target, requests, frames := newFakeRTSP(t)
+
+	proxy, proxyURL, err := NewScaleProxy("rtsp://admin:secret@"+target+"/Streaming/tracks/101?starttime=20240501T100000Z", 4)
+	if err != nil {
+		t.Fatalf("NewScaleProxy: %v", err)
+	}
+	defer proxy.Close()
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host == target || u.Path != "/Streaming/tracks/101" || u.User.Username() != "admin" {
+		t.Fatalf("URL прокси %s", u.Redacted())
+	}
+
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	fmt.Fprintf(conn, "DESCRIBE %s RTSP/1.0\r\nCSeq: 1\r\nScale: 1.0\r\n\r\n", proxyURL)
+	readResponse(t, reader)
+
+	// RTCP пакет клиента содержит текст запроса и не должен разбираться
+	conn.Write([]byte{'$', 1, 0, 8, 'P', 'L', 'A', 'Y', ' ', 'x', '\r', '\n'})
+
+	fmt.Fprintf(conn, "PLAY %s RTSP/1.0\r\nCSeq: 2\r\nScale: 1.0\r\nRange: npt=0.000-\r\n\r\n", proxyURL)
+	readResponse(t, reader)
+
+	frame := make([]byte, 8)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		t.Fatalf("ошибка чтения RTP: %v", err)
+	}
+	if !bytes.Equal(frame, []byte{'$', 0, 0, 4, 'r', 't', 'p', '!'}) {
+		t.Fatalf("RTP пакет %q", frame)
+	}
+
+	describe := <-requests
+	if describe.method != "DESCRIBE" || !containsHeader(describe.headers, "Scale: 1.0") {
+		t.Fatalf("DESCRIBE изменен: %v", describe.headers)
+	}
+	if payload := <-frames; string(payload) != "PLAY x\r\n" {
+		t.Fatalf("RTCP пакет %q", payload)
+	}
+
+	play := <-requests
+	if play.method != "PLAY" {
+		t.Fatalf("ожидался PLAY, получен %s", play.method)
+	}
+	var scales []string
+	for _, h := range play.headers {
+		if strings.HasPrefix(h, "Scale:") {
+			scales = append(scales, h)
+		}
+	}
+	if len(scales) != 1 || scales[0] != "Scale: 4.0" {
+		t.Fatalf("заголовки Scale в PLAY: %v", scales)
+	}
+	if !containsHeader(play.headers, "Range: npt=0.000-") {
+		t.Fatalf("заголовки PLAY: %v", play.headers)
+	}
+}
+
+func TestScaleProxyCloseDropsConnections(t *testing.T) {
+	target, _, _ := newFakeRTSP(t)
+
+	proxy, proxyURL, err := NewScaleProxy("rtsp://"+target+"/Streaming/tracks/101", 2)
+	if err != nil {
+		t.Fatalf("NewScaleProxy: %v", err)
+	}
+	u, _ := url.Parse(proxyURL)
+
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "OPTIONS %s RTSP/1.0\r\nCSeq: 1\r\n\r\n", proxyURL)
+	readResponse(t, bufio.NewReader(conn))
+
+	proxy.Close()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("соединение не закрыто после остановки прокси")
+	}
+	if _, err := net.DialTimeout("tcp", u.Host, time.Second); err == nil {
+		t.Fatal("прокси принимает подключения после остановки")
+	}
+}
+
+func TestScaleProxyInvalidURL(t *testing.T) {
+	if _, _, err := NewScaleProxy("http://nvr/Streaming/tracks/101", 2); err == nil {
+		t.Fatal("ожидалась ошибка для не RTSP адреса")
+	}
+}
+
+// containsHeader проверяет наличие заголовка
+func containsHeader(headers []string, header string) bool {
+	for _, h := range headers {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+// internal/hikvision/search.go
+package hikvision
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// searchWorkers - число одновременных поисков по каналам. Регистраторы
+// плохо переносят параллельные запросы, поэтому пул ограничен.
+const searchWorkers = 4
+
+// ChannelRecordings - результат поиска записей одного канала
+type ChannelRecordings struct {
+	Channel    string      `json:"channel"`
+	Recordings []Recording `json:"recordings"`
+	Count      int         `json:"count"`
+	Error      string      `json:"error,omitempty"`
+
+	Err error `json:"-"`
+}
+
+// SearchChannels ищет записи нескольких каналов одновременно.
+// Результаты возвращаются в порядке channelIDs; ошибка одного канала
+// не прерывает поиск по остальным.
+func SearchChannels(ctx context.Context, channelIDs []string, startTime, endTime time.Time, event string) []ChannelRecordings {
+	results := make([]ChannelRecordings, len(channelIDs))
+	jobs := make(chan int)
+
+	workers := searchWorkers
+	if len(channelIDs) < workers {
+		workers = len(channelIDs)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = searchChannel(ctx, channelIDs[i], startTime, endTime, event)
+			}
+		}()
+	}
+
+	for i := range channelIDs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// searchChannel выполняет поиск одного канала для SearchChannels
+func searchChannel(ctx context.Context, channelID string, startTime, endTime time.Time, event string) ChannelRecordings {
+	result := ChannelRecordings{Channel: channelID, Recordings: []Recording{}}
+
+	recordings, err := SearchRecordings(ctx, channelID, startTime, endTime, event)
+	if err != nil {
+		result.Err = err
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Recordings = recordings
+	result.Count = len(recordings)
+	return result
+}
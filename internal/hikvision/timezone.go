@@ -0,0 +1,109 @@
+// internal/hikvision/timezone.go
+package hikvision
+
+import (
+	"TeleOko/internal/timeutil"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Время кеширования часового пояса устройства
+const (
+	locationTTL      = time.Hour
+	locationRetryTTL = time.Minute // после ошибки используем пояс сервера
+	locationTimeout  = 5 * time.Second
+)
+
+// deviceTime - ответ ISAPI /System/time
+type deviceTime struct {
+	LocalTime string `xml:"localTime"`
+	TimeZone  string `xml:"timeZone"`
+}
+
+// Location возвращает часовой пояс устройства из ISAPI /System/time.
+// Если устройство недоступно, используется последний известный пояс
+// устройства или пояс сервера.
+func (c *Client) Location(ctx context.Context) *time.Location {
+	c.locMu.Lock()
+	if c.loc != nil && time.Now().Before(c.locExpires) {
+		loc := c.loc
+		c.locMu.Unlock()
+		return loc
+	}
+
+	// Запрос к устройству выполняется один на всех ожидающих и не
+	// зависит от отмены контекста вызывающего
+	done := c.locFetch
+	if done == nil {
+		done = make(chan struct{})
+		c.locFetch = done
+		go c.refreshLocation(context.WithoutCancel(ctx), done)
+	}
+	c.locMu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	c.locMu.Lock()
+	defer c.locMu.Unlock()
+	if c.loc == nil {
+		// Вызывающий перестал ждать раньше ответа устройства
+		return time.Local
+	}
+	return c.loc
+}
+
+// refreshLocation обновляет кеш часового пояса устройства
+func (c *Client) refreshLocation(ctx context.Context, done chan struct{}) {
+	loc, err := c.fetchLocation(ctx)
+
+	c.locMu.Lock()
+	defer c.locMu.Unlock()
+	defer close(done)
+	c.locFetch = nil
+
+	if err != nil {
+		if c.loc == nil {
+			log.Printf("⚠️ [%s] Часовой пояс устройства недоступен, используется пояс сервера: %v", c.device.ID, err)
+			c.loc = time.Local
+		} else {
+			log.Printf("⚠️ [%s] Часовой пояс устройства недоступен, используется прежний %s: %v", c.device.ID, c.loc, err)
+		}
+		c.locExpires = time.Now().Add(locationRetryTTL)
+		return
+	}
+
+	c.loc = loc
+	c.locExpires = time.Now().Add(locationTTL)
+}
+
+// fetchLocation запрашивает время и часовой пояс устройства
+func (c *Client) fetchLocation(ctx context.Context) (*time.Location, error) {
+	ctx, cancel := context.WithTimeout(ctx, locationTimeout)
+	defer cancel()
+
+	body, err := c.do(ctx, "получение времени устройства", http.MethodGet, "/ISAPI/System/time", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var info deviceTime
+	if err := xml.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга XML ответа: %v", err)
+	}
+
+	// Смещение в localTime учитывает текущее летнее время
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(info.LocalTime)); err == nil && !strings.HasSuffix(info.LocalTime, "Z") {
+		_, offset := t.Zone()
+		return time.FixedZone(timeutil.FormatOffset(offset), offset), nil
+	}
+
+	return timeutil.ParsePOSIXZone(info.TimeZone)
+}
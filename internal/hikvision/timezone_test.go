@@ -0,0 +1,121 @@
+// internal/hikvision/timezone_test.go
+package hikvision
+
+import (
+	"TeleOko/internal/config"
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTimeServer запускает сервер, отвечающий на /ISAPI/System/time после release
+func newTimeServer(t *testing.T, requests *atomic.Int32, release <-chan struct{}) *Client {
+	t.Helper()
+
+	server := newISAPIServer(t, false, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ISAPI/System/time" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		<-release
+		w.Write([]byte("<Time><localTime>2024-05-01T10:00:00+05:00</localTime><timeZone>CST-5:00:00</timeZone></Time>"))
+	})
+
+	return NewClient(config.Device{
+		ID:       "nvr",
+		Vendor:   config.VendorHikvision,
+		IP:       "127.0.0.1",
+		HTTPPort: serverPort(t, server),
+	})
+}
+
+func TestLocationFromDevice(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	close(release)
+	client := newTimeServer(t, &requests, release)
+
+	loc := client.Location(context.Background())
+	if _, offset := time.Date(2024, 5, 1, 0, 0, 0, 0, loc).Zone(); offset != 5*3600 {
+		t.Fatalf("смещение %d, ожидалось UTC+5", offset)
+	}
+
+	client.Location(context.Background())
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("запросов к устройству: %d, ожидался 1 (кеш)", n)
+	}
+}
+
+func TestLocationCancelledCallerDoesNotCache(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	client := newTimeServer(t, &requests, release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if loc := client.Location(ctx); loc != time.Local {
+		t.Fatalf("отмененный вызов вернул %s", loc)
+	}
+
+	// Запрос к устройству продолжается без вызывающего
+	close(release)
+	waitLocation(t, client)
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("запросов к устройству: %d", n)
+	}
+}
+
+func TestLocationSingleFetch(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	client := newTimeServer(t, &requests, release)
+
+	var wg sync.WaitGroup
+	results := make(chan *time.Location, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- client.Location(context.Background())
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for loc := range results {
+		if loc == time.Local {
+			t.Fatal("вызов получил пояс сервера вместо пояса устройства")
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("запросов к устройству: %d, ожидался 1", n)
+	}
+}
+
+// waitLocation ждет, пока часовой пояс устройства попадет в кеш
+func waitLocation(t *testing.T, client *Client) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.locMu.Lock()
+		loc := client.loc
+		client.locMu.Unlock()
+		if loc != nil {
+			if loc == time.Local {
+				t.Fatal("в кеше пояс сервера")
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("часовой пояс не получен")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
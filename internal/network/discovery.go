@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"time"
 )
 
@@ -41,29 +42,27 @@ func getLocalIPFromInterfaces() (string, error) {
 	return "127.0.0.1", nil
 }
 
-// TestCameraConnection проверяет доступность камеры
-func TestCameraConnection() error {
-	ip, username, password, port := config.GetHikvisionCredentials()
-
+// TestCameraConnection проверяет доступность устройства
+func TestCameraConnection(device *config.Device) error {
 	// Проверяем TCP подключение к RTSP порту
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(device.IP, strconv.Itoa(device.RTSPPort))
 	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
 	if err != nil {
 		return fmt.Errorf("не удалось подключиться к %s: %v", address, err)
 	}
 	defer conn.Close()
 
-	log.Printf("✅ Камера %s:%d доступна (пользователь: %s)", ip, port, username)
-
-	// Для полной проверки можно добавить RTSP OPTIONS запрос
-	_ = password // используем переменную чтобы избежать предупреждения
+	log.Printf("✅ Устройство %s (%s) доступно (пользователь: %s)", device.ID, address, device.Username)
 
 	return nil
 }
 
 // GetCameraRTSPURL формирует RTSP URL для канала
-func GetCameraRTSPURL(channelID string) string {
-	ip, username, password, port := config.GetHikvisionCredentials()
+func GetCameraRTSPURL(channelID string) (string, error) {
+	channel, device, err := config.ResolveChannel(channelID)
+	if err != nil {
+		return "", err
+	}
 	return fmt.Sprintf("rtsp://%s:%s@%s:%d/Streaming/Channels/%s",
-		username, password, ip, port, channelID)
+		device.Username, device.Password, device.IP, device.RTSPPort, channel.DeviceChannelID()), nil
 }
@@ -0,0 +1,64 @@
+// internal/playback/clock.go
+package playback
+
+import "time"
+
+// clock - шкала времени воспроизведения. Позиция вычисляется по времени
+// с последнего запуска и скорости, поэтому сервер знает ее без обратной
+// связи от плеера. Все методы вызываются под мьютексом владельца.
+type clock struct {
+	position time.Time // позиция на момент anchor
+	anchor   time.Time // когда воспроизведение было запущено с position
+	paused   bool
+	speed    int
+}
+
+// at возвращает позицию на момент now, не дальше end
+func (c *clock) at(now, end time.Time) time.Time {
+	if c.paused {
+		return c.position
+	}
+
+	position := c.position.Add(now.Sub(c.anchor) * time.Duration(c.speed))
+	if position.After(end) {
+		return end
+	}
+	return position
+}
+
+// seek переносит позицию на t с момента now
+func (c *clock) seek(t, now time.Time) {
+	c.position = t
+	c.anchor = now
+}
+
+// pause фиксирует позицию на момент now; false, если уже на паузе
+func (c *clock) pause(now, end time.Time) bool {
+	if c.paused {
+		return false
+	}
+	c.position = c.at(now, end)
+	c.paused = true
+	return true
+}
+
+// resume продолжает отсчет с момента now; false, если не было паузы
+func (c *clock) resume(now time.Time) bool {
+	if !c.paused {
+		return false
+	}
+	c.paused = false
+	c.anchor = now
+	return true
+}
+
+// setSpeed меняет скорость с момента now; false, если скорость та же
+func (c *clock) setSpeed(speed int, now, end time.Time) bool {
+	if speed == c.speed {
+		return false
+	}
+	c.position = c.at(now, end)
+	c.anchor = now
+	c.speed = speed
+	return true
+}
@@ -0,0 +1,427 @@
+// internal/playback/group.go
+package playback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ошибки групп воспроизведения
+var (
+	ErrGroupNotFound  = errors.New("группа воспроизведения не найдена")
+	ErrStreamNotFound = errors.New("канал не входит в группу")
+	ErrGroupSize      = errors.New("недопустимое число каналов в группе")
+)
+
+// Ограничения групп воспроизведения
+const (
+	// MaxGroupStreams - максимум каналов в одной группе
+	MaxGroupStreams = 16
+	// driftTolerance - расхождение, после которого поток считается рассинхронизированным
+	driftTolerance = 2 * time.Second
+)
+
+// Group - синхронное воспроизведение архива нескольких каналов.
+// Все потоки группы живут по общей шкале: переход, пауза и смена
+// скорости применяются ко всем сразу.
+type Group struct {
+	mu sync.Mutex
+
+	id    string
+	owner string
+	start time.Time
+	end   time.Time
+
+	clock   clock
+	members []*Session
+	reports map[string]report // последние позиции от плеера по каналам
+
+	lastActive activity
+}
+
+// report - позиция потока, о которой сообщил плеер
+type report struct {
+	position time.Time
+	at       time.Time
+}
+
+// GroupState - состояние группы для API
+type GroupState struct {
+	ID       string        `json:"id"`
+	Start    string        `json:"start"`
+	End      string        `json:"end"`
+	Position string        `json:"position"`
+	Paused   bool          `json:"paused"`
+	Speed    int           `json:"speed"`
+	Ended    bool          `json:"ended"`
+	Streams  []StreamState `json:"streams"`
+}
+
+// StreamState - состояние потока группы. DriftMs - отставание (<0) или
+// опережение (>0) плеера относительно общей шкалы по последнему отчету.
+type StreamState struct {
+	Channel    string `json:"channel"`
+	StreamID   string `json:"stream_id"`
+	Position   string `json:"position,omitempty"`
+	DriftMs    *int64 `json:"drift_ms,omitempty"`
+	ReportedAt string `json:"reported_at,omitempty"`
+	OutOfSync  bool   `json:"out_of_sync"`
+}
+
+var (
+	groups   = make(map[string]*Group)
+	groupsMu sync.Mutex
+)
+
+// CreateGroup запускает воспроизведение каналов за интервал с общей шкалой
+func CreateGroup(ctx context.Context, owner string, channelIDs []string, start, end time.Time, speed int) (*Group, error) {
+	if len(channelIDs) == 0 || len(channelIDs) > MaxGroupStreams {
+		return nil, fmt.Errorf("%w: %d (1-%d)", ErrGroupSize, len(channelIDs), MaxGroupStreams)
+	}
+	if !validSpeed(speed) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidSpeed, speed)
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("%w: окончание раньше начала", ErrOutOfRange)
+	}
+
+	now := time.Now()
+	g := &Group{
+		id:      uuid.New().String(),
+		owner:   owner,
+		start:   start,
+		end:     end,
+		clock:   clock{position: start, anchor: now, speed: speed},
+		reports: make(map[string]report),
+	}
+	g.lastActive.touch(now)
+	for _, channelID := range channelIDs {
+		g.members = append(g.members, newSession(owner, channelID, "group_", start, end, speed, now))
+	}
+
+	err := g.forEach(func(s *Session) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.apply(ctx)
+	})
+	if err != nil {
+		g.release()
+		return nil, err
+	}
+
+	groupsMu.Lock()
+	cleanupGroupsLocked()
+	groups[g.id] = g
+	groupsMu.Unlock()
+
+	log.Printf("▶️ Группа воспроизведения %s: каналы %v, %s - %s", g.id, channelIDs,
+		start.Format(time.RFC3339), end.Format(time.RFC3339))
+	return g, nil
+}
+
+// GetGroup возвращает группу по ID. Если owner не пуст, группы других
+// пользователей не видны.
+func GetGroup(id, owner string) (*Group, error) {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+
+	cleanupGroupsLocked()
+	g, ok := groups[id]
+	if !ok || (owner != "" && g.owner != owner) {
+		return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, id)
+	}
+
+	g.lastActive.touch(time.Now())
+	return g, nil
+}
+
+// ListGroups возвращает состояния групп пользователя (пустой owner - все группы)
+func ListGroups(owner string) []GroupState {
+	groupsMu.Lock()
+	cleanupGroupsLocked()
+	list := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		if owner == "" || g.owner == owner {
+			list = append(list, g)
+		}
+	}
+	groupsMu.Unlock()
+
+	states := make([]GroupState, 0, len(list))
+	for _, g := range list {
+		states = append(states, g.State())
+	}
+	return states
+}
+
+// DeleteGroup завершает группу и удаляет потоки всех ее каналов
+func DeleteGroup(id, owner string) error {
+	g, err := GetGroup(id, owner)
+	if err != nil {
+		return err
+	}
+
+	groupsMu.Lock()
+	delete(groups, id)
+	groupsMu.Unlock()
+
+	g.release()
+	log.Printf("⏹️ Группа воспроизведения %s завершена", id)
+	return nil
+}
+
+// ID возвращает идентификатор группы
+func (g *Group) ID() string {
+	return g.id
+}
+
+// Member возвращает поток группы для канала
+func (g *Group) Member(channelID string) (*Session, error) {
+	for _, s := range g.members {
+		if s.channel == channelID {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrStreamNotFound, channelID)
+}
+
+// State возвращает общую позицию группы и расхождение каждого потока
+func (g *Group) State() GroupState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	position := g.clock.at(time.Now(), g.end)
+	state := GroupState{
+		ID:       g.id,
+		Start:    g.start.Format(time.RFC3339),
+		End:      g.end.Format(time.RFC3339),
+		Position: position.Format(time.RFC3339),
+		Paused:   g.clock.paused,
+		Speed:    g.clock.speed,
+		Ended:    !position.Before(g.end),
+		Streams:  make([]StreamState, 0, len(g.members)),
+	}
+
+	for _, s := range g.members {
+		stream := StreamState{Channel: s.channel, StreamID: s.streamID}
+		if r, ok := g.reports[s.channel]; ok {
+			drift := r.position.Sub(g.clock.at(r.at, g.end)).Milliseconds()
+			stream.Position = r.position.Format(time.RFC3339)
+			stream.DriftMs = &drift
+			stream.ReportedAt = r.at.Format(time.RFC3339)
+			stream.OutOfSync = time.Duration(abs(drift))*time.Millisecond > driftTolerance
+		}
+		state.Streams = append(state.Streams, stream)
+	}
+	return state
+}
+
+// Report сохраняет позиции, которые плеер показывает по каналам группы.
+// Расхождение с общей шкалой возвращается в State.
+func (g *Group) Report(positions map[string]time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for channelID := range positions {
+		if _, err := g.Member(channelID); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for channelID, position := range positions {
+		g.reports[channelID] = report{position: position, at: now}
+	}
+	return nil
+}
+
+// Seek переводит все потоки группы к указанному времени
+func (g *Group) Seek(ctx context.Context, t time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if t.Before(g.start) || !t.Before(g.end) {
+		return fmt.Errorf("%w: %s", ErrOutOfRange, t.Format(time.RFC3339))
+	}
+
+	now := time.Now()
+	snapshot := g.snapshot()
+	g.clock.seek(t, now)
+	return g.update(ctx, snapshot, now, func(s *Session) error {
+		return s.seek(ctx, t, now)
+	})
+}
+
+// Pause ставит все потоки группы на паузу в одной позиции
+func (g *Group) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if !g.clock.pause(now, g.end) {
+		return
+	}
+	for _, s := range g.members {
+		s.pause(now)
+	}
+}
+
+// Resume продолжает воспроизведение всех потоков группы
+func (g *Group) Resume(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	snapshot := g.snapshot()
+	if !g.clock.resume(now) {
+		return nil
+	}
+	return g.update(ctx, snapshot, now, func(s *Session) error {
+		return s.resume(ctx, now)
+	})
+}
+
+// SetSpeed меняет скорость всех потоков группы
+func (g *Group) SetSpeed(ctx context.Context, speed int) error {
+	if !validSpeed(speed) {
+		return fmt.Errorf("%w: %d", ErrInvalidSpeed, speed)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	snapshot := g.snapshot()
+	if !g.clock.setSpeed(speed, now, g.end) {
+		return nil
+	}
+	return g.update(ctx, snapshot, now, func(s *Session) error {
+		return s.setSpeed(ctx, speed, now)
+	})
+}
+
+// groupSnapshot - состояние группы до изменения шкалы
+type groupSnapshot struct {
+	clock   clock
+	reports map[string]report
+	members []clock
+}
+
+// snapshot запоминает часы и отчеты группы и часы ее потоков
+// (вызывается под g.mu)
+func (g *Group) snapshot() groupSnapshot {
+	snapshot := groupSnapshot{
+		clock:   g.clock,
+		reports: g.reports,
+		members: make([]clock, len(g.members)),
+	}
+	for i, s := range g.members {
+		s.mu.Lock()
+		snapshot.members[i] = s.clock
+		s.mu.Unlock()
+	}
+	return snapshot
+}
+
+// update применяет новую шкалу ко всем потокам группы (вызывается под g.mu).
+// Если хотя бы один поток не переключился, группа возвращается к snapshot,
+// а уже переключенные потоки - к позиции прежней шкалы, чтобы все потоки
+// по-прежнему шли синхронно.
+func (g *Group) update(ctx context.Context, snapshot groupSnapshot, now time.Time, fn func(s *Session) error) error {
+	g.resetReports()
+
+	var mu sync.Mutex
+	switched := make(map[*Session]bool, len(g.members))
+	err := g.forEach(func(s *Session) error {
+		if err := fn(s); err != nil {
+			return err
+		}
+		mu.Lock()
+		switched[s] = true
+		mu.Unlock()
+		return nil
+	})
+	if err == nil {
+		return nil
+	}
+
+	g.clock = snapshot.clock
+	g.reports = snapshot.reports
+
+	// Откат выполняется и после отмены запроса, иначе потоки разойдутся
+	restoreCtx := context.WithoutCancel(ctx)
+	for i, s := range g.members {
+		if !switched[s] {
+			continue
+		}
+		if restoreErr := s.restore(restoreCtx, snapshot.members[i], now); restoreErr != nil {
+			log.Printf("⚠️ Группа %s: канал %s не возвращен к прежней позиции: %v", g.id, s.channel, restoreErr)
+		}
+	}
+	return err
+}
+
+// forEach выполняет действие для всех потоков параллельно, чтобы они
+// перезапустились почти одновременно. Возвращает первую ошибку.
+func (g *Group) forEach(fn func(s *Session) error) error {
+	errs := make([]error, len(g.members))
+
+	var wg sync.WaitGroup
+	for i, s := range g.members {
+		wg.Add(1)
+		go func(i int, s *Session) {
+			defer wg.Done()
+			if err := fn(s); err != nil {
+				errs[i] = fmt.Errorf("канал %s: %w", s.channel, err)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetReports сбрасывает отчеты плеера: после перезапуска потоков
+// они больше не соответствуют шкале (вызывается под g.mu)
+func (g *Group) resetReports() {
+	g.reports = make(map[string]report)
+}
+
+// release удаляет потоки всех каналов группы
+func (g *Group) release() {
+	for _, s := range g.members {
+		s.release()
+	}
+}
+
+// cleanupGroupsLocked удаляет давно неиспользуемые группы
+// (вызывается под groupsMu)
+func cleanupGroupsLocked() {
+	now := time.Now()
+	for id, g := range groups {
+		if g.lastActive.idle(now) {
+			delete(groups, id)
+			go g.release()
+			log.Printf("⏱️ Группа воспроизведения %s простаивает, удаление", id)
+		}
+	}
+}
+
+// abs возвращает модуль числа
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
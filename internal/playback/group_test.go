@@ -0,0 +1,148 @@
+// internal/playback/group_test.go
+package playback
+
+import (
+	"TeleOko/internal/config"
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addGroup регистрирует группу без потоков
+func addGroup(t *testing.T, lastActive time.Time) *Group {
+	t.Helper()
+
+	g := &Group{id: t.Name(), reports: make(map[string]report)}
+	g.lastActive.touch(lastActive)
+
+	groupsMu.Lock()
+	groups[g.id] = g
+	groupsMu.Unlock()
+	t.Cleanup(func() {
+		groupsMu.Lock()
+		delete(groups, g.id)
+		groupsMu.Unlock()
+	})
+	return g
+}
+
+func TestGetGroupWhileGroupBusy(t *testing.T) {
+	g := addGroup(t, time.Now())
+
+	// Seek и SetSpeed держат g.mu на время запросов к регистратору
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := GetGroup(g.id, "")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("GetGroup ждет мьютекс занятой группы")
+	}
+}
+
+func TestCleanupIdleGroups(t *testing.T) {
+	idle := addGroup(t, time.Now().Add(-sessionIdleTimeout-time.Minute))
+	idle.mu.Lock()
+	defer idle.mu.Unlock()
+
+	if _, err := GetGroup(idle.id, ""); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("ожидалась ErrGroupNotFound для простаивающей группы, получено %v", err)
+	}
+}
+
+// useChannels настраивает каналы 101 и 102 регистратора без ISAPI
+func useChannels(t *testing.T) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	previousDevices, previousChannels := config.GlobalConfig.Devices, config.GlobalConfig.Channels
+	config.GlobalConfig.Devices = []config.Device{{
+		ID:       "nvr",
+		Vendor:   config.VendorHikvision,
+		IP:       "127.0.0.1",
+		RTSPPort: 554,
+		HTTPPort: httpPort,
+	}}
+	config.GlobalConfig.Channels = []config.Channel{
+		{ID: "101", Device: "nvr"},
+		{ID: "102", Device: "nvr"},
+	}
+	t.Cleanup(func() {
+		config.GlobalConfig.Devices, config.GlobalConfig.Channels = previousDevices, previousChannels
+	})
+}
+
+func TestGroupRollsBackWhenMemberFails(t *testing.T) {
+	fake := newFakeGo2RTC(t)
+	useChannels(t)
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	g, err := CreateGroup(context.Background(), "", []string{"101", "102"}, start, start.Add(time.Hour), 1)
+	if err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+	t.Cleanup(func() { DeleteGroup(g.id, "") })
+
+	if err := g.Report(map[string]time.Time{"101": start}); err != nil {
+		t.Fatal(err)
+	}
+	before := g.clock
+	first, _ := g.Member("101")
+
+	// go2rtc не принимает новый источник канала 102
+	fake.mu.Lock()
+	fake.fail = "/tracks/102"
+	fake.mu.Unlock()
+
+	if err := g.SetSpeed(context.Background(), 4); err == nil {
+		t.Fatal("ожидалась ошибка смены скорости")
+	}
+	if err := g.Seek(context.Background(), start.Add(30*time.Minute)); err == nil {
+		t.Fatal("ожидалась ошибка перехода")
+	}
+
+	if g.clock != before {
+		t.Fatalf("часы группы %+v, ожидались %+v", g.clock, before)
+	}
+	if _, ok := g.reports["101"]; !ok {
+		t.Fatal("отчеты плеера сброшены после отката")
+	}
+
+	now := time.Now()
+	for _, s := range g.members {
+		if s.clock.speed != 1 || s.proxy != nil {
+			t.Errorf("канал %s: скорость %d после отката", s.channel, s.clock.speed)
+		}
+		if drift := s.clock.at(now, s.end).Sub(g.clock.at(now, g.end)); drift < -time.Second || drift > time.Second {
+			t.Errorf("канал %s расходится с группой на %v", s.channel, drift)
+		}
+	}
+
+	// Переключенный поток канала 101 вернулся к началу интервала без прокси
+	src, err := url.Parse(fake.source(first.streamID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Port() != "554" || strings.Contains(src.RawQuery, "T1030") {
+		t.Fatalf("источник канала 101 после отката: %s", src.Redacted())
+	}
+}
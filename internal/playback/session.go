@@ -0,0 +1,400 @@
+// internal/playback/session.go
+package playback
+
+import (
+	"TeleOko/internal/go2rtc"
+	"TeleOko/internal/hikvision"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ошибки сессий воспроизведения
+var (
+	ErrSessionNotFound = errors.New("сессия воспроизведения не найдена")
+	ErrInvalidSpeed    = errors.New("недопустимая скорость воспроизведения")
+	ErrOutOfRange      = errors.New("время вне интервала сессии")
+)
+
+// Speeds - допустимые скорости воспроизведения
+var Speeds = []int{1, 2, 4, 8}
+
+// sessionIdleTimeout - через сколько удаляется сессия без обращений
+const sessionIdleTimeout = 30 * time.Minute
+
+// Session - состояние воспроизведения архива одного зрителя
+type Session struct {
+	mu sync.Mutex
+
+	id       string
+	owner    string
+	channel  string
+	streamID string                // временный поток go2rtc
+	proxy    *hikvision.ScaleProxy // ускоренное воспроизведение через регистратор
+
+	start time.Time
+	end   time.Time
+
+	clock      clock
+	lastActive activity
+}
+
+// State - состояние сессии для API
+type State struct {
+	ID       string `json:"id"`
+	StreamID string `json:"stream_id"`
+	Channel  string `json:"channel"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Position string `json:"position"`
+	Paused   bool   `json:"paused"`
+	Speed    int    `json:"speed"`
+	Ended    bool   `json:"ended"`
+}
+
+var (
+	sessions   = make(map[string]*Session)
+	sessionsMu sync.Mutex
+)
+
+// Create создает сессию воспроизведения канала за интервал и регистрирует
+// ее поток в go2rtc. owner - имя пользователя (пусто без авторизации).
+func Create(ctx context.Context, owner, channelID string, start, end time.Time, speed int) (*Session, error) {
+	if !validSpeed(speed) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidSpeed, speed)
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("%w: окончание раньше начала", ErrOutOfRange)
+	}
+
+	s := newSession(owner, channelID, "session_", start, end, speed, time.Now())
+
+	s.mu.Lock()
+	err := s.apply(ctx)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	sessionsMu.Lock()
+	cleanupSessionsLocked()
+	sessions[s.id] = s
+	sessionsMu.Unlock()
+
+	log.Printf("▶️ Сессия воспроизведения %s: канал %s, %s - %s", s.id, channelID,
+		start.Format(time.RFC3339), end.Format(time.RFC3339))
+	return s, nil
+}
+
+// newSession создает сессию без регистрации потока. kind отличает
+// потоки одиночных сессий и групп в go2rtc.
+func newSession(owner, channelID, kind string, start, end time.Time, speed int, now time.Time) *Session {
+	id := uuid.New().String()
+	s := &Session{
+		id:       id,
+		owner:    owner,
+		channel:  channelID,
+		streamID: go2rtc.PlaybackStreamPrefix + kind + id,
+		start:    start,
+		end:      end,
+		clock:    clock{position: start, anchor: now, speed: speed},
+	}
+	s.lastActive.touch(now)
+	return s
+}
+
+// Get возвращает сессию по ID. Если owner не пуст, сессии других
+// пользователей не видны.
+func Get(id, owner string) (*Session, error) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	cleanupSessionsLocked()
+	s, ok := sessions[id]
+	if !ok || (owner != "" && s.owner != owner) {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	s.touch()
+	return s, nil
+}
+
+// List возвращает состояния сессий пользователя (пустой owner - все сессии)
+func List(owner string) []State {
+	sessionsMu.Lock()
+	cleanupSessionsLocked()
+	list := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		if owner == "" || s.owner == owner {
+			list = append(list, s)
+		}
+	}
+	sessionsMu.Unlock()
+
+	states := make([]State, 0, len(list))
+	for _, s := range list {
+		states = append(states, s.State())
+	}
+	return states
+}
+
+// Delete завершает сессию и удаляет ее поток
+func Delete(id, owner string) error {
+	s, err := Get(id, owner)
+	if err != nil {
+		return err
+	}
+
+	sessionsMu.Lock()
+	delete(sessions, id)
+	sessionsMu.Unlock()
+
+	s.release()
+	log.Printf("⏹️ Сессия воспроизведения %s завершена", id)
+	return nil
+}
+
+// ID возвращает идентификатор сессии
+func (s *Session) ID() string {
+	return s.id
+}
+
+// State возвращает текущее состояние сессии
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	position := s.clock.at(time.Now(), s.end)
+	return State{
+		ID:       s.id,
+		StreamID: s.streamID,
+		Channel:  s.channel,
+		Start:    s.start.Format(time.RFC3339),
+		End:      s.end.Format(time.RFC3339),
+		Position: position.Format(time.RFC3339),
+		Paused:   s.clock.paused,
+		Speed:    s.clock.speed,
+		Ended:    !position.Before(s.end),
+	}
+}
+
+// Position возвращает текущую позицию воспроизведения
+func (s *Session) Position() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.at(time.Now(), s.end)
+}
+
+// Seek переходит к указанному времени
+func (s *Session) Seek(ctx context.Context, t time.Time) error {
+	return s.seek(ctx, t, time.Now())
+}
+
+// Pause останавливает воспроизведение на текущей позиции. Плеер закрывает
+// WebRTC соединение, и go2rtc перестает читать архив.
+func (s *Session) Pause() {
+	s.pause(time.Now())
+}
+
+// Resume продолжает воспроизведение с позиции паузы
+func (s *Session) Resume(ctx context.Context) error {
+	return s.resume(ctx, time.Now())
+}
+
+// SetSpeed меняет скорость воспроизведения с текущей позиции
+func (s *Session) SetSpeed(ctx context.Context, speed int) error {
+	if !validSpeed(speed) {
+		return fmt.Errorf("%w: %d", ErrInvalidSpeed, speed)
+	}
+	return s.setSpeed(ctx, speed, time.Now())
+}
+
+// seek переходит к времени t с момента now. Группа передает общий now,
+// чтобы шкалы всех потоков совпадали.
+func (s *Session) seek(ctx context.Context, t, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.Before(s.start) || !t.Before(s.end) {
+		return fmt.Errorf("%w: %s", ErrOutOfRange, t.Format(time.RFC3339))
+	}
+
+	s.touch()
+	previous := s.clock
+	s.clock.seek(t, now)
+	if s.clock.paused {
+		return nil
+	}
+	return s.applyOrRestore(ctx, previous)
+}
+
+// pause ставит сессию на паузу с момента now
+func (s *Session) pause(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.touch()
+	s.clock.pause(now, s.end)
+}
+
+// resume продолжает воспроизведение с момента now
+func (s *Session) resume(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.touch()
+	previous := s.clock
+	if !s.clock.resume(now) {
+		return nil
+	}
+	return s.applyOrRestore(ctx, previous)
+}
+
+// setSpeed меняет скорость с момента now
+func (s *Session) setSpeed(ctx context.Context, speed int, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.touch()
+	previous := s.clock
+	if !s.clock.setSpeed(speed, now, s.end) || s.clock.paused {
+		return nil
+	}
+	return s.applyOrRestore(ctx, previous)
+}
+
+// restore возвращает сессию к часам previous с момента now и переключает
+// поток на позицию, которую они показывают. Группа вызывает restore для
+// потоков, уже переключенных на новую шкалу, если другой поток не смог.
+func (s *Session) restore(ctx context.Context, previous clock, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock = previous
+	if s.clock.paused {
+		// Поток на паузе переключится при продолжении воспроизведения
+		return nil
+	}
+	s.clock.seek(s.clock.at(now, s.end), now)
+	return s.apply(ctx)
+}
+
+// touch отмечает обращение к сессии
+func (s *Session) touch() {
+	s.lastActive.touch(time.Now())
+}
+
+// applyOrRestore применяет новое состояние часов, а при ошибке возвращает
+// прежнее, чтобы позиция сессии соответствовала потоку (вызывается под s.mu)
+func (s *Session) applyOrRestore(ctx context.Context, previous clock) error {
+	if err := s.apply(ctx); err != nil {
+		s.clock = previous
+		return err
+	}
+	return nil
+}
+
+// apply переключает поток go2rtc на текущую позицию и скорость
+// (вызывается под s.mu). Плеер после этого заново подключается к потоку.
+func (s *Session) apply(ctx context.Context) error {
+	playbackURL, err := hikvision.GetPlaybackURL(ctx, s.channel, s.clock.position, s.end)
+	if err != nil {
+		return err
+	}
+
+	src := playbackURL
+	var proxy *hikvision.ScaleProxy
+	if s.clock.speed > 1 {
+		// Ускорение выполняет регистратор: go2rtc получает архив через
+		// прокси, который добавляет RTSP Scale в запрос PLAY
+		proxy, src, err = hikvision.NewScaleProxy(playbackURL, s.clock.speed)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.switchStream(src, proxy)
+}
+
+// switchStream заменяет источник потока сессии (вызывается под s.mu).
+// Поток не удаляется заранее: если go2rtc не принял новый источник,
+// зритель остается на прежнем, а новый прокси останавливается.
+func (s *Session) switchStream(src string, proxy *hikvision.ScaleProxy) error {
+	if err := go2rtc.UpdatePlaybackStream(s.streamID, src, s.owner); err != nil {
+		if proxy != nil {
+			proxy.Close()
+		}
+		return err
+	}
+
+	if s.proxy != nil {
+		s.proxy.Close()
+	}
+	s.proxy = proxy
+	return nil
+}
+
+// release удаляет поток сессии из go2rtc
+func (s *Session) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseStream()
+}
+
+// releaseStream удаляет поток go2rtc и останавливает прокси ускорения
+// (вызывается под s.mu)
+func (s *Session) releaseStream() {
+	if err := go2rtc.ReleasePlaybackStream(s.streamID); err != nil && !errors.Is(err, go2rtc.ErrStreamNotFound) {
+		log.Printf("⚠️ Ошибка удаления потока %s: %v", s.streamID, err)
+	}
+	if s.proxy != nil {
+		s.proxy.Close()
+		s.proxy = nil
+	}
+}
+
+// cleanupSessionsLocked удаляет давно неиспользуемые сессии
+// (вызывается под sessionsMu)
+func cleanupSessionsLocked() {
+	now := time.Now()
+	for id, s := range sessions {
+		if s.lastActive.idle(now) {
+			delete(sessions, id)
+			go s.release()
+			log.Printf("⏱️ Сессия воспроизведения %s простаивает, удаление", id)
+		}
+	}
+}
+
+// activity - время последнего обращения. Читается без мьютекса владельца,
+// чтобы очистка под sessionsMu и groupsMu не ждала сетевых запросов,
+// которые выполняются под s.mu и g.mu.
+type activity struct {
+	unixNano atomic.Int64
+}
+
+// touch отмечает обращение в момент now
+func (a *activity) touch(now time.Time) {
+	a.unixNano.Store(now.UnixNano())
+}
+
+// idle сообщает, что обращений не было дольше sessionIdleTimeout
+func (a *activity) idle(now time.Time) bool {
+	return now.Sub(time.Unix(0, a.unixNano.Load())) > sessionIdleTimeout
+}
+
+// validSpeed проверяет, поддерживается ли скорость
+func validSpeed(speed int) bool {
+	for _, v := range Speeds {
+		if v == speed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+// internal/playback/session_test.go
+package playback
+
+import (
+	"TeleOko/internal/config"
+	"TeleOko/internal/go2rtc"
+	"TeleOko/internal/hikvision"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionRestoresClockOnError(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	now := time.Now()
+	// Канала нет в конфигурации, поэтому пересоздать поток не получится
+	s := newSession("", "missing", "session_", start, start.Add(time.Hour), 1, now)
+	before := s.clock
+
+	if err := s.seek(context.Background(), start.Add(30*time.Minute), now); err == nil {
+		t.Fatal("ожидалась ошибка перехода")
+	}
+	if s.clock != before {
+		t.Fatalf("часы после неудачного перехода %+v, ожидались %+v", s.clock, before)
+	}
+
+	if err := s.setSpeed(context.Background(), 4, now); err == nil {
+		t.Fatal("ожидалась ошибка смены скорости")
+	}
+	if s.clock != before {
+		t.Fatalf("часы после неудачной смены скорости %+v, ожидались %+v", s.clock, before)
+	}
+
+	s.pause(now)
+	paused := s.clock
+	if err := s.resume(context.Background(), now.Add(time.Second)); err == nil {
+		t.Fatal("ожидалась ошибка продолжения")
+	}
+	if s.clock != paused {
+		t.Fatalf("часы после неудачного продолжения %+v, ожидались %+v", s.clock, paused)
+	}
+}
+
+// fakeGo2RTC запускает API потоков go2rtc в памяти. PUT и PATCH отвечают
+// ошибкой для источников, содержащих fail.
+type fakeGo2RTC struct {
+	mu      sync.Mutex
+	sources map[string]string
+	fail    string
+}
+
+func newFakeGo2RTC(t *testing.T) *fakeGo2RTC {
+	t.Helper()
+
+	fake := &fakeGo2RTC{sources: make(map[string]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		query := r.URL.Query()
+		switch r.Method {
+		case http.MethodPut, http.MethodPatch:
+			if fake.fail != "" && strings.Contains(query.Get("src"), fake.fail) {
+				http.Error(w, "source error", http.StatusInternalServerError)
+				return
+			}
+			fake.sources[query.Get("name")] = query.Get("src")
+		case http.MethodDelete:
+			delete(fake.sources, query.Get("src"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	previous := config.GlobalConfig.Go2RTC.Port
+	config.GlobalConfig.Go2RTC.Port = server.Listener.Addr().(*net.TCPAddr).Port
+	t.Cleanup(func() { config.GlobalConfig.Go2RTC.Port = previous })
+	return fake
+}
+
+// source возвращает источник потока в go2rtc
+func (f *fakeGo2RTC) source(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sources[name]
+}
+
+// newProxy запускает прокси ускорения и возвращает адрес, на котором он слушает
+func newProxy(t *testing.T) (*hikvision.ScaleProxy, string) {
+	t.Helper()
+	proxy, proxyURL, err := hikvision.NewScaleProxy("rtsp://127.0.0.1:554/Streaming/tracks/101", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	u, _ := url.Parse(proxyURL)
+	return proxy, u.Host
+}
+
+// listening проверяет, принимает ли адрес подключения
+func listening(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func TestSessionKeepsStreamWhenSwitchFails(t *testing.T) {
+	fake := newFakeGo2RTC(t)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	s := newSession("admin", "101", "session_", start, start.Add(time.Hour), 1, time.Now())
+	t.Cleanup(s.release)
+
+	oldProxy, oldAddr := newProxy(t)
+	if err := s.switchStream("rtsp://nvr/first", oldProxy); err != nil {
+		t.Fatalf("регистрация потока: %v", err)
+	}
+
+	fake.mu.Lock()
+	fake.fail = "second"
+	fake.mu.Unlock()
+
+	nextProxy, nextAddr := newProxy(t)
+	if err := s.switchStream("rtsp://nvr/second", nextProxy); err == nil {
+		t.Fatal("ожидалась ошибка замены источника")
+	}
+	if src := fake.source(s.streamID); src != "rtsp://nvr/first" {
+		t.Fatalf("источник потока после ошибки %q, ожидался прежний", src)
+	}
+	if owner, err := go2rtc.PlaybackStreamOwner(s.streamID); err != nil || owner != "admin" {
+		t.Fatalf("поток снят с учета после ошибки: %q, %v", owner, err)
+	}
+	if s.proxy != oldProxy || !listening(oldAddr) {
+		t.Fatal("прежний прокси остановлен после ошибки")
+	}
+	if listening(nextAddr) {
+		t.Fatal("новый прокси не остановлен после ошибки")
+	}
+
+	fake.mu.Lock()
+	fake.fail = ""
+	fake.mu.Unlock()
+
+	if err := s.switchStream("rtsp://nvr/third", nil); err != nil {
+		t.Fatalf("замена источника: %v", err)
+	}
+	if src := fake.source(s.streamID); src != "rtsp://nvr/third" {
+		t.Fatalf("источник потока %q", src)
+	}
+	if s.proxy != nil || listening(oldAddr) {
+		t.Fatal("прежний прокси не остановлен после замены источника")
+	}
+}
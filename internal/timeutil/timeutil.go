@@ -0,0 +1,225 @@
+// internal/timeutil/timeutil.go
+package timeutil
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ошибки разбора времени
+var (
+	ErrInvalidTime  = errors.New("неверный формат времени")
+	ErrInvalidRange = errors.New("неверный интервал времени")
+)
+
+// Допустимый диапазон лет: защищает регистратор от заведомо ошибочных запросов
+const (
+	minYear = 1970
+	maxYear = 2099
+)
+
+// Форматы времени ISAPI и RTSP. Hikvision передает время устройства
+// с суффиксом Z, хотя это местное время регистратора, а не UTC.
+const (
+	isapiLayout = "2006-01-02T15:04:05Z"
+	rtspLayout  = "20060102T150405Z"
+)
+
+// Форматы времени без часового пояса; время считается местным для loc
+var localLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+}
+
+// Форматы даты без времени
+var dateLayouts = []string{
+	"02.01.2006",
+	"2006-01-02",
+}
+
+var unixPattern = regexp.MustCompile(`^\d{9,13}$`)
+
+// Parse разбирает время в формате ISO 8601/RFC 3339, dd.mm.yyyy
+// (с временем или без) или Unix timestamp в секундах/миллисекундах.
+// Время без часового пояса считается местным временем loc.
+func Parse(s string, loc *time.Location) (time.Time, error) {
+	t, _, err := parse(s, loc)
+	return t, err
+}
+
+// ParseRange разбирает границы интервала. Дата без времени означает
+// начало дня для start и конец дня для end. Пустой end означает конец
+// дня, к которому относится start.
+func ParseRange(start, end string, loc *time.Location) (time.Time, time.Time, error) {
+	startTime, _, err := parse(start, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("начало: %w", err)
+	}
+
+	var endTime time.Time
+	if strings.TrimSpace(end) == "" {
+		endTime = EndOfDay(startTime)
+	} else {
+		var dateOnly bool
+		endTime, dateOnly, err = parse(end, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("окончание: %w", err)
+		}
+		if dateOnly {
+			endTime = EndOfDay(endTime)
+		}
+	}
+
+	if !endTime.After(startTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: окончание %s не позже начала %s",
+			ErrInvalidRange, endTime.Format(time.RFC3339), startTime.Format(time.RFC3339))
+	}
+
+	return startTime, endTime, nil
+}
+
+// parse разбирает время и сообщает, была ли указана только дата
+func parse(s string, loc *time.Location) (time.Time, bool, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false, fmt.Errorf("%w: пустое значение", ErrInvalidTime)
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+
+	var t time.Time
+	dateOnly := false
+	parsed := false
+
+	if unixPattern.MatchString(s) {
+		value, _ := strconv.ParseInt(s, 10, 64)
+		if len(s) > 10 {
+			t = time.UnixMilli(value).In(loc)
+		} else {
+			t = time.Unix(value, 0).In(loc)
+		}
+		parsed = true
+	}
+
+	if !parsed {
+		if v, err := time.Parse(time.RFC3339, s); err == nil {
+			t, parsed = v, true
+		} else if v, err := ParseRTSP(s, loc); err == nil {
+			t, parsed = v, true
+		}
+	}
+
+	for _, layout := range localLayouts {
+		if parsed {
+			break
+		}
+		if v, err := time.ParseInLocation(layout, s, loc); err == nil {
+			t, parsed = v, true
+		}
+	}
+
+	for _, layout := range dateLayouts {
+		if parsed {
+			break
+		}
+		if v, err := time.ParseInLocation(layout, s, loc); err == nil {
+			t, parsed, dateOnly = v, true, true
+		}
+	}
+
+	if !parsed {
+		return time.Time{}, false, fmt.Errorf("%w: %q (ожидается ISO 8601, дд.мм.гггг или Unix время)", ErrInvalidTime, s)
+	}
+	if t.Year() < minYear || t.Year() > maxYear {
+		return time.Time{}, false, fmt.Errorf("%w: год %d вне диапазона %d-%d", ErrInvalidTime, t.Year(), minYear, maxYear)
+	}
+
+	return t, dateOnly, nil
+}
+
+// EndOfDay возвращает последнюю секунду дня t в его часовом поясе
+func EndOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 23, 59, 59, 0, t.Location())
+}
+
+// FormatISAPI форматирует время для ISAPI запросов в часовом поясе устройства
+func FormatISAPI(t time.Time, deviceLoc *time.Location) string {
+	return t.In(deviceLoc).Format(isapiLayout)
+}
+
+// FormatRTSP форматирует время для RTSP URL архива в часовом поясе устройства
+func FormatRTSP(t time.Time, deviceLoc *time.Location) string {
+	return t.In(deviceLoc).Format(rtspLayout)
+}
+
+// ParseISAPI разбирает время из ответа ISAPI. Суффикс Z означает местное
+// время устройства; явное смещение (+03:00) учитывается как есть.
+func ParseISAPI(s string, deviceLoc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "Z") {
+		if t, err := time.ParseInLocation(isapiLayout, s, deviceLoc); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTime, s)
+}
+
+// ParseRTSP разбирает время из RTSP URL архива. Как и в ISAPI, суффикс Z
+// означает местное время устройства.
+func ParseRTSP(s string, deviceLoc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation(rtspLayout, strings.TrimSpace(s), deviceLoc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTime, s)
+	}
+	return t, nil
+}
+
+var posixZonePattern = regexp.MustCompile(`^([A-Za-z]+|<[^>]+>)([+-]?)(\d{1,2})(?::(\d{2}))?(?::(\d{2}))?`)
+
+// ParsePOSIXZone разбирает часовой пояс Hikvision в формате POSIX TZ,
+// например CST-3:00:00 (UTC+3). Правила перехода на летнее время
+// не учитываются.
+func ParsePOSIXZone(tz string) (*time.Location, error) {
+	m := posixZonePattern.FindStringSubmatch(strings.TrimSpace(tz))
+	if m == nil {
+		return nil, fmt.Errorf("неизвестный часовой пояс: %q", tz)
+	}
+
+	hours, _ := strconv.Atoi(m[3])
+	minutes, _ := strconv.Atoi(m[4])
+	seconds, _ := strconv.Atoi(m[5])
+	if hours > 24 || minutes > 59 || seconds > 59 {
+		return nil, fmt.Errorf("неверное смещение часового пояса: %q", tz)
+	}
+
+	// В POSIX TZ знак смещения обратный: CST-3 означает UTC+3
+	offset := hours*3600 + minutes*60 + seconds
+	if m[2] != "-" {
+		offset = -offset
+	}
+
+	return time.FixedZone(FormatOffset(offset), offset), nil
+}
+
+// FormatOffset форматирует смещение в секундах как UTC+03:00
+func FormatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("UTC%s%02d:%02d", sign, offset/3600, offset%3600/60)
+}
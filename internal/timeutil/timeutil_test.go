@@ -0,0 +1,171 @@
+// internal/timeutil/timeutil_test.go
+package timeutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeviceLocalZ(t *testing.T) {
+	loc := time.FixedZone("UTC+03:00", 3*3600)
+	want := time.Date(2024, 5, 1, 10, 0, 0, 0, loc)
+
+	// Суффикс Z в форматах Hikvision - местное время устройства
+	isapi, err := ParseISAPI("2024-05-01T10:00:00Z", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtsp, err := ParseRTSP("20240501T100000Z", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse("20240501T100000Z", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, got := range map[string]time.Time{"ParseISAPI": isapi, "ParseRTSP": rtsp, "Parse": parsed} {
+		if !got.Equal(want) {
+			t.Errorf("%s: %s, ожидалось %s", name, got, want)
+		}
+	}
+
+	if s := FormatRTSP(want, loc); s != "20240501T100000Z" {
+		t.Errorf("FormatRTSP: %s", s)
+	}
+}
+
+func TestParseExplicitOffset(t *testing.T) {
+	loc := time.FixedZone("UTC+03:00", 3*3600)
+
+	got, err := Parse("2024-05-01T10:00:00+05:00", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 5, 1, 5, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("%s, ожидалось %s", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	moscow := time.FixedZone("UTC+03:00", 3*3600)
+	newYork := time.FixedZone("UTC-05:00", -5*3600)
+
+	tests := []struct {
+		name  string
+		input string
+		loc   *time.Location
+		want  time.Time
+	}{
+		{name: "RFC3339 UTC", input: "2024-05-01T10:00:00Z", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{name: "RFC3339 со смещением", input: "2024-05-01T10:00:00+05:00", loc: moscow, want: time.Date(2024, 5, 1, 5, 0, 0, 0, time.UTC)},
+		{name: "RTSP", input: "20240501T100000Z", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 0, 0, moscow)},
+		{name: "ISO без пояса", input: "2024-05-01T10:00:30", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 30, 0, moscow)},
+		{name: "ISO без секунд", input: "2024-05-01T10:00", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 0, 0, moscow)},
+		{name: "дата и время через пробел", input: "2024-05-01 10:00:30", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 30, 0, moscow)},
+		{name: "дата и время без секунд", input: "2024-05-01 10:00", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 0, 0, moscow)},
+		{name: "местное время в другом поясе", input: "2024-05-01 10:00", loc: newYork, want: time.Date(2024, 5, 1, 15, 0, 0, 0, time.UTC)},
+		{name: "дд.мм.гггг с временем", input: "01.05.2024 10:00:30", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 30, 0, moscow)},
+		{name: "дд.мм.гггг без секунд", input: "01.05.2024 10:00", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 0, 0, moscow)},
+		{name: "дд.мм.гггг", input: "01.05.2024", loc: moscow, want: time.Date(2024, 5, 1, 0, 0, 0, 0, moscow)},
+		{name: "гггг-мм-дд", input: "2024-05-01", loc: newYork, want: time.Date(2024, 5, 1, 0, 0, 0, 0, newYork)},
+		{name: "пробелы по краям", input: "  01.05.2024  ", loc: moscow, want: time.Date(2024, 5, 1, 0, 0, 0, 0, moscow)},
+		{name: "Unix 9 цифр", input: "123456789", loc: moscow, want: time.Unix(123456789, 0)},
+		{name: "Unix секунды", input: "1714557600", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{name: "Unix 11 цифр - миллисекунды", input: "17145576001", loc: moscow, want: time.UnixMilli(17145576001)},
+		{name: "Unix миллисекунды", input: "1714557600123", loc: moscow, want: time.Date(2024, 5, 1, 10, 0, 0, 123e6, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.input, tt.loc)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.input, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("Parse(%q) = %s, ожидалось %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	loc := time.FixedZone("UTC+03:00", 3*3600)
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "пусто", input: "  "},
+		{name: "текст", input: "вчера"},
+		{name: "несуществующая дата", input: "32.13.2024"},
+		{name: "30 февраля", input: "2024-02-30"},
+		{name: "неверное время", input: "01.05.2024 25:00"},
+		{name: "год до 1970", input: "31.12.1969"},
+		{name: "год после 2099", input: "2100-01-01T00:00:00Z"},
+		{name: "RTSP после 2099", input: "21000101T000000Z"},
+		{name: "Unix 8 цифр", input: "12345678"},
+		{name: "Unix 14 цифр", input: "17145576001234"},
+		{name: "отрицательный Unix", input: "-1714557600"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Parse(tt.input, loc); !errors.Is(err, ErrInvalidTime) {
+				t.Fatalf("Parse(%q) = %s, %v, ожидалась ErrInvalidTime", tt.input, got, err)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	loc := time.FixedZone("UTC+03:00", 3*3600)
+
+	tests := []struct {
+		name       string
+		start, end string
+		wantStart  time.Time
+		wantEnd    time.Time
+	}{
+		{
+			name:      "даты без времени",
+			start:     "01.05.2024",
+			end:       "02.05.2024",
+			wantStart: time.Date(2024, 5, 1, 0, 0, 0, 0, loc),
+			wantEnd:   time.Date(2024, 5, 2, 23, 59, 59, 0, loc),
+		},
+		{
+			name:      "без окончания",
+			start:     "2024-05-01 10:00",
+			wantStart: time.Date(2024, 5, 1, 10, 0, 0, 0, loc),
+			wantEnd:   time.Date(2024, 5, 1, 23, 59, 59, 0, loc),
+		},
+		{
+			name:      "время не сдвигается к концу дня",
+			start:     "2024-05-01T10:00:00Z",
+			end:       "2024-05-01T11:00:00Z",
+			wantStart: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := ParseRange(tt.start, tt.end, loc)
+			if err != nil {
+				t.Fatalf("ParseRange: %v", err)
+			}
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Fatalf("интервал %s - %s, ожидался %s - %s", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+
+	if _, _, err := ParseRange("2024-05-01 11:00", "2024-05-01 10:00", loc); !errors.Is(err, ErrInvalidRange) {
+		t.Fatalf("ожидалась ErrInvalidRange, получено %v", err)
+	}
+	if _, _, err := ParseRange("32.13.2024", "", loc); !errors.Is(err, ErrInvalidTime) {
+		t.Fatalf("ожидалась ErrInvalidTime, получено %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+// internal/webhook/storage.go
+package webhook
+
+import (
+	"TeleOko/internal/config"
+	"TeleOko/internal/hikvision"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// deadLetterMaxSize - размер журнала недоставленных, после которого
+	// он переименовывается в .1 и начинается заново
+	deadLetterMaxSize = 10 << 20
+	// snapshotTimeout - ограничение времени получения снимка
+	snapshotTimeout = 10 * time.Second
+	// snapshotTTL - сколько хранится снимок, на который ссылается вебхук
+	snapshotTTL = 24 * time.Hour
+	// SnapshotPathPrefix - путь, по которому получатели скачивают снимки
+	SnapshotPathPrefix = "/webhook-snapshots/"
+)
+
+// snapshotName - имя снимка: случайный UUID, чтобы ссылку нельзя было подобрать
+var snapshotName = regexp.MustCompile(`^[0-9a-f-]{36}\.jpg$`)
+
+// DeadLetter - событие, которое не удалось доставить
+type DeadLetter struct {
+	Time     time.Time `json:"time"`
+	Webhook  string    `json:"webhook"`
+	URL      string    `json:"url"`
+	Delivery string    `json:"delivery"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Payload  Payload   `json:"payload"`
+}
+
+var (
+	deadLetterMu sync.Mutex
+	snapshotMu   sync.Mutex
+	lastCleanup  time.Time
+)
+
+// writeDeadLetter дописывает событие в журнал недоставленных
+func writeDeadLetter(entry DeadLetter) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("❌ Ошибка сериализации недоставленного события: %v", err)
+		return
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	path := deadLetterPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("❌ Ошибка создания каталога %s: %v", filepath.Dir(path), err)
+		return
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > deadLetterMaxSize {
+		os.Rename(path, path+".1")
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("❌ Ошибка открытия журнала недоставленных: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("❌ Ошибка записи журнала недоставленных: %v", err)
+	}
+}
+
+// DeadLetters возвращает до limit последних недоставленных событий,
+// от новых к старым
+func DeadLetters(limit int) ([]DeadLetter, error) {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	entries := make([]DeadLetter, 0, limit)
+	f, err := os.Open(deadLetterPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Журнал читается целиком, в памяти остаются последние limit записей
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() {
+		var entry DeadLetter
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if len(entries) == limit {
+			entries = entries[1:]
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// captureSnapshot сохраняет снимок канала события и возвращает ссылку
+// на него или пустую строку, если снимок получить не удалось
+func captureSnapshot(ctx context.Context, event hikvision.Event, baseURL string) string {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	data, err := hikvision.GetSnapshot(ctx, event.Channel)
+	if err != nil {
+		log.Printf("⚠️ Ошибка снимка для вебхука, событие %s: %v", event.ID, err)
+		return ""
+	}
+
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	dir := snapshotsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("❌ Ошибка создания каталога %s: %v", dir, err)
+		return ""
+	}
+	cleanupSnapshotsLocked(dir)
+
+	name := uuid.New().String() + ".jpg"
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		log.Printf("❌ Ошибка сохранения снимка для вебхука: %v", err)
+		return ""
+	}
+	return baseURL + SnapshotPathPrefix + name
+}
+
+// pendingSnapshot - снимок канала, который получается параллельно
+// с рассылкой события
+type pendingSnapshot struct {
+	done chan struct{}
+	url  string
+}
+
+// startSnapshot начинает получение снимка канала события
+func startSnapshot(ctx context.Context, event hikvision.Event, baseURL string) *pendingSnapshot {
+	s := &pendingSnapshot{done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.url = captureSnapshot(ctx, event, baseURL)
+	}()
+	return s
+}
+
+// wait возвращает ссылку на снимок или пустую строку, если снимок
+// получить не удалось или отменен ctx
+func (s *pendingSnapshot) wait(ctx context.Context) string {
+	select {
+	case <-s.done:
+		return s.url
+	case <-ctx.Done():
+		return ""
+	}
+}
+
+// SnapshotPath возвращает путь к снимку вебхука по имени или пустую
+// строку, если имя неверно или срок хранения снимка истек
+func SnapshotPath(name string) string {
+	if !snapshotName.MatchString(name) {
+		return ""
+	}
+
+	path := filepath.Join(snapshotsDir(), name)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > snapshotTTL {
+		return ""
+	}
+	return path
+}
+
+// cleanupSnapshotsLocked не чаще раза в час удаляет просроченные снимки
+// (вызывается под snapshotMu)
+func cleanupSnapshotsLocked(dir string) {
+	if time.Since(lastCleanup) < time.Hour {
+		return
+	}
+	lastCleanup = time.Now()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > snapshotTTL {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+// deadLetterPath возвращает путь к журналу недоставленных событий
+func deadLetterPath() string {
+	return filepath.Join(config.GetDataDir(), "webhooks", "dead-letters.jsonl")
+}
+
+// snapshotsDir возвращает каталог снимков для вебхуков
+func snapshotsDir() string {
+	return filepath.Join(config.GetDataDir(), "webhooks", "snapshots")
+}
@@ -0,0 +1,458 @@
+// internal/webhook/webhook.go
+package webhook
+
+import (
+	"TeleOko/internal/config"
+	"TeleOko/internal/events"
+	"TeleOko/internal/hikvision"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Заголовки запроса вебхука
+const (
+	HeaderEvent     = "X-TeleOko-Event"
+	HeaderDelivery  = "X-TeleOko-Delivery"
+	HeaderTimestamp = "X-TeleOko-Timestamp"
+	// HeaderSignature - "sha256=" и HMAC-SHA256 ключа от "<timestamp>.<тело>"
+	HeaderSignature = "X-TeleOko-Signature"
+)
+
+// EventTest - тип проверочного события
+const EventTest = "test"
+
+const (
+	// queueSize - очередь доставки каждого вебхука
+	queueSize = 256
+	// requestTimeout - ограничение времени одного запроса
+	requestTimeout = 10 * time.Second
+	// defaultMaxRetries - повторы доставки по умолчанию
+	defaultMaxRetries = 5
+	// Пауза между повторами удваивается от retryMinDelay до retryMaxDelay
+	retryMinDelay = time.Second
+	retryMaxDelay = time.Minute
+	// repeatWindow - повторные active сообщения события в этом окне не отправляются
+	repeatWindow = 10 * time.Second
+	// maxErrorBody - сколько байт ответа с ошибкой попадает в текст ошибки
+	maxErrorBody = 512
+)
+
+// ErrNotFound - вебхук с таким именем не настроен
+var ErrNotFound = errors.New("вебхук не найден")
+
+// Payload - тело запроса: событие и ссылка на снимок канала
+type Payload struct {
+	hikvision.Event
+	Webhook     string `json:"webhook"`
+	SnapshotURL string `json:"snapshot_url,omitempty"`
+
+	snapshot *pendingSnapshot // снимок, который еще получается
+	delivery string           // X-TeleOko-Delivery, общий для всех попыток доставки
+}
+
+// Stats - состояние вебхука для API
+type Stats struct {
+	Name        string   `json:"name"`
+	URL         string   `json:"url"`
+	Channels    []string `json:"channels,omitempty"`
+	Events      []string `json:"events,omitempty"`
+	Signed      bool     `json:"signed"`
+	Snapshot    bool     `json:"snapshot"`
+	Queued      int      `json:"queued"`
+	Delivered   int      `json:"delivered"`
+	Failed      int      `json:"failed"`
+	LastError   string   `json:"last_error,omitempty"`
+	LastSuccess string   `json:"last_success,omitempty"`
+	LastFailure string   `json:"last_failure,omitempty"`
+}
+
+// hook - настроенный вебхук с очередью доставки
+type hook struct {
+	cfg      config.WebhookConfig
+	channels map[string]bool
+	types    map[string]bool
+	queue    chan Payload
+
+	mu          sync.Mutex
+	delivered   int
+	failed      int
+	lastError   string
+	lastSuccess time.Time
+	lastFailure time.Time
+}
+
+// deliveryError - ошибка доставки; permanent означает, что повтор не поможет
+type deliveryError struct {
+	status    int
+	message   string
+	permanent bool
+}
+
+func (e *deliveryError) Error() string {
+	return e.message
+}
+
+var (
+	hooks      []*hook
+	hooksMu    sync.RWMutex
+	httpClient = &http.Client{Timeout: requestTimeout}
+)
+
+// Start запускает отправку событий на настроенные вебхуки до отмены ctx.
+// fallbackURL - адрес TeleOko для ссылок на снимки, если public_url не задан.
+// Вызывается до events.Start, чтобы не потерять первые события.
+func Start(ctx context.Context, fallbackURL string) {
+	configs := config.GetWebhooks()
+	if len(configs) == 0 {
+		return
+	}
+
+	baseURL := strings.TrimRight(config.GetWebhookPublicURL(), "/")
+	if baseURL == "" {
+		baseURL = fallbackURL
+	}
+
+	list := make([]*hook, 0, len(configs))
+	for _, cfg := range configs {
+		if cfg.Name == "" || cfg.URL == "" {
+			log.Printf("⚠️ Вебхук без имени или адреса пропущен")
+			continue
+		}
+		h := newHook(cfg)
+		list = append(list, h)
+		go h.run(ctx)
+	}
+
+	hooksMu.Lock()
+	hooks = list
+	hooksMu.Unlock()
+
+	ch, unsubscribe := events.SubscribeBuffered(queueSize)
+	go dispatch(ctx, ch, unsubscribe, baseURL)
+
+	log.Printf("🪝 Вебхуки: %d", len(list))
+}
+
+// newHook создает вебхук из настроек
+func newHook(cfg config.WebhookConfig) *hook {
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	h := &hook{
+		cfg:      cfg,
+		channels: make(map[string]bool),
+		types:    make(map[string]bool),
+		queue:    make(chan Payload, queueSize),
+	}
+	for _, channelID := range cfg.Channels {
+		h.channels[channelID] = true
+	}
+	for _, eventType := range cfg.Events {
+		h.types[strings.ToLower(eventType)] = true
+	}
+	return h
+}
+
+// dispatch раскладывает события по очередям подходящих вебхуков.
+// Повторные сообщения active одного события отправляются один раз.
+func dispatch(ctx context.Context, ch <-chan hikvision.Event, unsubscribe func(), baseURL string) {
+	defer unsubscribe()
+
+	lastSeen := make(map[string]time.Time)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if repeated(lastSeen, event, time.Now()) {
+				continue
+			}
+
+			var matched []*hook
+			snapshot := false
+			for _, h := range list() {
+				if h.match(event) {
+					matched = append(matched, h)
+					snapshot = snapshot || h.cfg.Snapshot
+				}
+			}
+			if len(matched) == 0 {
+				continue
+			}
+
+			// Снимок получается параллельно: его ждет только доставка
+			// вебхуков со снимком, а не рассылка остальных событий
+			var pending *pendingSnapshot
+			if snapshot && event.Channel != "" && event.State == hikvision.EventStateActive {
+				pending = startSnapshot(ctx, event, baseURL)
+			}
+
+			for _, h := range matched {
+				payload := Payload{Event: event, Webhook: h.cfg.Name}
+				if h.cfg.Snapshot {
+					payload.snapshot = pending
+				}
+				h.enqueue(payload)
+			}
+		}
+	}
+}
+
+// repeated проверяет, продолжает ли сообщение уже отправленное событие
+func repeated(lastSeen map[string]time.Time, event hikvision.Event, now time.Time) bool {
+	key := event.Device + "/" + event.DeviceChannel + "/" + event.RawType
+	if event.State != hikvision.EventStateActive {
+		delete(lastSeen, key)
+		return false
+	}
+
+	last, ok := lastSeen[key]
+	lastSeen[key] = now
+	return ok && now.Sub(last) <= repeatWindow
+}
+
+// list возвращает настроенные вебхуки
+func list() []*hook {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	return hooks
+}
+
+// find возвращает вебхук по имени
+func find(name string) (*hook, error) {
+	for _, h := range list() {
+		if h.cfg.Name == name {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// List возвращает состояние всех вебхуков
+func List() []Stats {
+	all := list()
+	stats := make([]Stats, 0, len(all))
+	for _, h := range all {
+		stats = append(stats, h.stats())
+	}
+	return stats
+}
+
+// Test синхронно отправляет на вебхук проверочное событие без повторов
+func Test(ctx context.Context, name string) error {
+	h, err := find(name)
+	if err != nil {
+		return err
+	}
+
+	payload := Payload{
+		Event: hikvision.Event{
+			ID:          uuid.New().String(),
+			Device:      events.SystemDevice,
+			Type:        EventTest,
+			RawType:     EventTest,
+			State:       hikvision.EventStateActive,
+			Description: "Проверка вебхука",
+			Time:        time.Now(),
+		},
+		Webhook:  h.cfg.Name,
+		delivery: uuid.New().String(),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return h.post(ctx, payload, body)
+}
+
+// match проверяет событие по фильтрам вебхука
+func (h *hook) match(event hikvision.Event) bool {
+	if len(h.channels) > 0 && !h.channels[event.Channel] {
+		return false
+	}
+	return len(h.types) == 0 || h.types[event.Type]
+}
+
+// enqueue ставит событие в очередь. Если вебхук не успевает принимать
+// события, событие сразу попадает в журнал недоставленных. Идентификатор
+// доставки создается здесь, чтобы получатель узнавал повторы по нему.
+func (h *hook) enqueue(payload Payload) {
+	payload.delivery = uuid.New().String()
+	select {
+	case h.queue <- payload:
+	default:
+		log.Printf("⚠️ Очередь вебхука %s переполнена, событие %s не отправлено", h.cfg.Name, payload.ID)
+		h.fail(payload, 0, errors.New("очередь доставки переполнена"))
+	}
+}
+
+// run отправляет события из очереди по порядку до отмены ctx
+func (h *hook) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-h.queue:
+			h.deliver(ctx, payload)
+		}
+	}
+}
+
+// deliver отправляет событие, повторяя попытки с растущей паузой.
+// После исчерпания попыток событие записывается в журнал недоставленных.
+func (h *hook) deliver(ctx context.Context, payload Payload) {
+	if payload.snapshot != nil {
+		payload.SnapshotURL = payload.snapshot.wait(ctx)
+		payload.snapshot = nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("❌ Ошибка сериализации события %s: %v", payload.ID, err)
+		return
+	}
+
+	delay := retryMinDelay
+	for attempt := 1; ; attempt++ {
+		err := h.post(ctx, payload, body)
+		if err == nil {
+			h.mu.Lock()
+			h.delivered++
+			h.lastSuccess = time.Now()
+			h.mu.Unlock()
+			return
+		}
+
+		var deliveryErr *deliveryError
+		permanent := errors.As(err, &deliveryErr) && deliveryErr.permanent
+		if permanent || attempt > h.cfg.MaxRetries || ctx.Err() != nil {
+			log.Printf("❌ Вебхук %s: событие %s не доставлено после %d попыток: %v",
+				h.cfg.Name, payload.ID, attempt, err)
+			h.fail(payload, attempt, err)
+			return
+		}
+
+		log.Printf("⚠️ Вебхук %s: ошибка доставки события %s (попытка %d), повтор через %v: %v",
+			h.cfg.Name, payload.ID, attempt, delay, err)
+		select {
+		case <-ctx.Done():
+			h.fail(payload, attempt, err)
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+// post выполняет одну попытку доставки
+func (h *hook) post(ctx context.Context, payload Payload, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return &deliveryError{message: fmt.Sprintf("неверный адрес вебхука: %v", err), permanent: true}
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TeleOko-Webhook")
+	req.Header.Set(HeaderEvent, payload.Type)
+	req.Header.Set(HeaderDelivery, payload.delivery)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if h.cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(h.cfg.Secret, timestamp, body))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+		return nil
+	}
+
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	message := fmt.Sprintf("HTTP %d", resp.StatusCode)
+	if s := strings.TrimSpace(string(text)); s != "" {
+		message += ": " + s
+	}
+	// 4xx кроме 408 и 429 означает, что получатель не примет событие и при повторе
+	permanent := resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests
+	return &deliveryError{status: resp.StatusCode, message: message, permanent: permanent}
+}
+
+// fail учитывает недоставленное событие и записывает его в журнал
+func (h *hook) fail(payload Payload, attempts int, err error) {
+	h.mu.Lock()
+	h.failed++
+	h.lastError = err.Error()
+	h.lastFailure = time.Now()
+	h.mu.Unlock()
+
+	writeDeadLetter(DeadLetter{
+		Time:     time.Now(),
+		Webhook:  h.cfg.Name,
+		URL:      h.cfg.URL,
+		Delivery: payload.delivery,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Payload:  payload,
+	})
+}
+
+// stats возвращает состояние вебхука
+func (h *hook) stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := Stats{
+		Name:      h.cfg.Name,
+		URL:       h.cfg.URL,
+		Channels:  h.cfg.Channels,
+		Events:    h.cfg.Events,
+		Signed:    h.cfg.Secret != "",
+		Snapshot:  h.cfg.Snapshot,
+		Queued:    len(h.queue),
+		Delivered: h.delivered,
+		Failed:    h.failed,
+		LastError: h.lastError,
+	}
+	if !h.lastSuccess.IsZero() {
+		stats.LastSuccess = h.lastSuccess.Format(time.RFC3339)
+	}
+	if !h.lastFailure.IsZero() {
+		stats.LastFailure = h.lastFailure.Format(time.RFC3339)
+	}
+	return stats
+}
+
+// Sign вычисляет подпись тела запроса: "sha256=" и HMAC-SHA256
+// ключа от "<timestamp>.<тело>" в hex. Получатель проверяет подпись
+// и время, чтобы отбросить подделанные и повторенные запросы.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,317 @@
+// internal/webhook/webhook_test.go
+package webhook
+
+import (
+	"TeleOko/internal/config"
+	"TeleOko/internal/hikvision"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// delivery - запрос, полученный тестовым получателем
+type delivery struct {
+	header  http.Header
+	body    []byte
+	payload Payload
+}
+
+// sink - получатель вебхуков; статус ответа на каждую попытку задает status
+type sink struct {
+	mu         sync.Mutex
+	deliveries []delivery
+	status     func(attempt int) int
+	received   chan delivery
+}
+
+// newSink запускает получателя вебхуков
+func newSink(t *testing.T, status func(attempt int) int) (*sink, string) {
+	t.Helper()
+
+	s := &sink{status: status, received: make(chan delivery, 16)}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server.URL
+}
+
+func (s *sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	d := delivery{header: r.Header.Clone(), body: body}
+	json.Unmarshal(body, &d.payload)
+
+	s.mu.Lock()
+	s.deliveries = append(s.deliveries, d)
+	attempt := len(s.deliveries)
+	s.mu.Unlock()
+
+	status := s.status(attempt)
+	if status >= 300 {
+		http.Error(w, "отклонено", status)
+	}
+	s.received <- d
+}
+
+// attempts возвращает число полученных запросов
+func (s *sink) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.deliveries)
+}
+
+// useDataDir направляет журнал недоставленных и снимки во временный каталог
+func useDataDir(t *testing.T) {
+	previous := config.GlobalConfig.Data.Dir
+	config.GlobalConfig.Data.Dir = t.TempDir()
+	t.Cleanup(func() { config.GlobalConfig.Data.Dir = previous })
+}
+
+// testPayload возвращает событие для отправки
+func testPayload(name string) Payload {
+	return Payload{
+		Event: hikvision.Event{
+			ID:      "event-1",
+			Device:  "nvr",
+			Channel: "101",
+			Type:    hikvision.EventMotion,
+			RawType: "VMD",
+			State:   hikvision.EventStateActive,
+			Time:    time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		},
+		Webhook:  name,
+		delivery: "delivery-1",
+	}
+}
+
+func TestDeliverSigned(t *testing.T) {
+	useDataDir(t)
+	s, url := newSink(t, func(int) int { return http.StatusOK })
+	h := newHook(config.WebhookConfig{Name: "hook", URL: url, Secret: "secret"})
+
+	h.deliver(context.Background(), testPayload("hook"))
+
+	d := s.deliveries[0]
+	timestamp := d.header.Get(HeaderTimestamp)
+	if timestamp == "" || d.header.Get(HeaderSignature) != Sign("secret", timestamp, d.body) {
+		t.Fatalf("подпись %q не соответствует телу", d.header.Get(HeaderSignature))
+	}
+	if !strings.HasPrefix(d.header.Get(HeaderSignature), "sha256=") {
+		t.Fatalf("формат подписи %q", d.header.Get(HeaderSignature))
+	}
+	if d.header.Get(HeaderEvent) != hikvision.EventMotion || d.header.Get(HeaderDelivery) == "" {
+		t.Fatalf("заголовки %v", d.header)
+	}
+	if d.payload.ID != "event-1" || d.payload.Webhook != "hook" || d.payload.Channel != "101" {
+		t.Fatalf("тело %s", d.body)
+	}
+
+	if stats := h.stats(); stats.Delivered != 1 || stats.Failed != 0 {
+		t.Fatalf("статистика %+v", stats)
+	}
+}
+
+func TestSignKnownValue(t *testing.T) {
+	// echo -n '1700000000.{}' | openssl dgst -sha256 -hmac secret
+	want := "sha256=b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163"
+	if got := Sign("secret", "1700000000", []byte("{}")); got != want {
+		t.Fatalf("подпись %q, ожидалась %q", got, want)
+	}
+}
+
+func TestDeliverRetriesServerErrors(t *testing.T) {
+	useDataDir(t)
+	s, url := newSink(t, func(attempt int) int {
+		if attempt == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	h := newHook(config.WebhookConfig{Name: "hook", URL: url})
+
+	h.deliver(context.Background(), testPayload("hook"))
+
+	if n := s.attempts(); n != 2 {
+		t.Fatalf("попыток: %d, ожидалось 2", n)
+	}
+	for i, d := range s.deliveries {
+		if id := d.header.Get(HeaderDelivery); id != "delivery-1" {
+			t.Errorf("попытка %d: идентификатор доставки %q, ожидался общий для повторов", i+1, id)
+		}
+	}
+	if stats := h.stats(); stats.Delivered != 1 || stats.Failed != 0 {
+		t.Fatalf("статистика %+v", stats)
+	}
+}
+
+func TestDeliverPermanentError(t *testing.T) {
+	useDataDir(t)
+	s, url := newSink(t, func(int) int { return http.StatusBadRequest })
+	h := newHook(config.WebhookConfig{Name: "hook", URL: url, MaxRetries: 3})
+
+	h.deliver(context.Background(), testPayload("hook"))
+
+	if n := s.attempts(); n != 1 {
+		t.Fatalf("попыток: %d, 4xx не должен повторяться", n)
+	}
+
+	letters, err := DeadLetters(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Attempts != 1 || !strings.Contains(letters[0].Error, "HTTP 400") {
+		t.Fatalf("журнал недоставленных %+v", letters)
+	}
+	if stats := h.stats(); stats.Failed != 1 || !strings.Contains(stats.LastError, "HTTP 400") {
+		t.Fatalf("статистика %+v", stats)
+	}
+}
+
+func TestDeliverDeadLetterAfterRetries(t *testing.T) {
+	useDataDir(t)
+	s, url := newSink(t, func(int) int { return http.StatusTooManyRequests })
+	h := newHook(config.WebhookConfig{Name: "hook", URL: url, MaxRetries: 1})
+
+	h.deliver(context.Background(), testPayload("hook"))
+
+	// 429 временная ошибка: первая попытка и один повтор
+	if n := s.attempts(); n != 2 {
+		t.Fatalf("попыток: %d, ожидалось 2", n)
+	}
+
+	letters, err := DeadLetters(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("журнал недоставленных %+v", letters)
+	}
+	letter := letters[0]
+	if letter.Webhook != "hook" || letter.URL != url || letter.Delivery != "delivery-1" || letter.Attempts != 2 || letter.Payload.ID != "event-1" {
+		t.Fatalf("запись журнала %+v", letter)
+	}
+}
+
+func TestDispatchDoesNotWaitForSnapshot(t *testing.T) {
+	useDataDir(t)
+
+	// Регистратор отдает снимок только после release
+	release := make(chan struct{})
+	nvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/picture") {
+			http.NotFound(w, r)
+			return
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("\xff\xd8jpeg"))
+	}))
+	t.Cleanup(nvr.Close)
+	defer close(release)
+
+	previous := config.GlobalConfig
+	config.GlobalConfig.Devices = []config.Device{{
+		ID:       "nvr",
+		Vendor:   config.VendorHikvision,
+		IP:       "127.0.0.1",
+		HTTPPort: nvr.Listener.Addr().(*net.TCPAddr).Port,
+	}}
+	config.GlobalConfig.Channels = []config.Channel{{ID: "101", Device: "nvr"}}
+	t.Cleanup(func() { config.GlobalConfig = previous })
+
+	withSnapshot, withSnapshotURL := newSink(t, func(int) int { return http.StatusOK })
+	plain, plainURL := newSink(t, func(int) int { return http.StatusOK })
+
+	// Доставка и рассылка останавливаются до восстановления настроек
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	list := []*hook{
+		newHook(config.WebhookConfig{Name: "snapshot", URL: withSnapshotURL, Snapshot: true}),
+		newHook(config.WebhookConfig{Name: "plain", URL: plainURL}),
+	}
+	for _, h := range list {
+		wg.Add(1)
+		go func(h *hook) {
+			defer wg.Done()
+			h.run(ctx)
+		}(h)
+	}
+	hooksMu.Lock()
+	previousHooks := hooks
+	hooks = list
+	hooksMu.Unlock()
+	t.Cleanup(func() {
+		hooksMu.Lock()
+		hooks = previousHooks
+		hooksMu.Unlock()
+	})
+
+	ch := make(chan hikvision.Event, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dispatch(ctx, ch, func() {}, "http://teleoko")
+	}()
+	ch <- testPayload("").Event
+
+	// Вебхук без снимка получает событие, пока снимок еще не готов
+	select {
+	case d := <-plain.received:
+		if d.payload.SnapshotURL != "" {
+			t.Fatalf("ссылка на снимок у вебхука без снимка: %s", d.payload.SnapshotURL)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("рассылка ждет получения снимка")
+	}
+	select {
+	case <-withSnapshot.received:
+		t.Fatal("событие отправлено до получения снимка")
+	default:
+	}
+
+	release <- struct{}{}
+	select {
+	case d := <-withSnapshot.received:
+		if !strings.HasPrefix(d.payload.SnapshotURL, "http://teleoko"+SnapshotPathPrefix) {
+			t.Fatalf("ссылка на снимок %q", d.payload.SnapshotURL)
+		}
+		name := strings.TrimPrefix(d.payload.SnapshotURL, "http://teleoko"+SnapshotPathPrefix)
+		if SnapshotPath(name) == "" {
+			t.Fatalf("снимок %s не сохранен", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("событие со снимком не доставлено")
+	}
+}
+
+func TestEnqueueOverflowDeadLetter(t *testing.T) {
+	useDataDir(t)
+	h := newHook(config.WebhookConfig{Name: "hook", URL: "http://127.0.0.1:1/hook"})
+	// Очередь без получателя: событие сразу уходит в журнал недоставленных
+	h.queue = make(chan Payload)
+
+	payload := testPayload("hook")
+	payload.delivery = ""
+	h.enqueue(payload)
+
+	letters, err := DeadLetters(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Attempts != 0 || letters[0].Delivery == "" {
+		t.Fatalf("журнал недоставленных %+v", letters)
+	}
+}
@@ -10,16 +10,19 @@ document.addEventListener('DOMContentLoaded', function() {
     const liveBtn = document.getElementById('liveBtn');
     const snapshotBtn = document.getElementById('snapshotBtn');
     const archiveDate = document.getElementById('archiveDate');
+    const archiveEvent = document.getElementById('archiveEvent');
     const searchBtn = document.getElementById('searchBtn');
     const timeline = document.getElementById('timeline');
     const recordingsList = document.getElementById('recordingsList');
     const loadingOverlay = document.getElementById('loadingOverlay');
     const loadingMessage = document.getElementById('loadingMessage');
+    const eventsList = document.getElementById('eventsList');
     
     // Текущее состояние приложения
     let currentVideoElement = null;
     let currentRTCPeerConnection = null;
     let currentStream = null;
+    let currentPlaybackStreamId = null;
     let recordings = [];
     let connectionStatus = 'offline';
     
@@ -313,6 +316,15 @@ document.addEventListener('DOMContentLoaded', function() {
             currentVideoElement = null;
         }
         
+        // Освобождаем временный поток архива в go2rtc
+        if (currentPlaybackStreamId) {
+            fetch('/api/webrtc/playback/' + currentPlaybackStreamId, {
+                method: 'DELETE',
+                keepalive: true
+            }).catch(function() {});
+            currentPlaybackStreamId = null;
+        }
+        
         updateConnectionStatus('offline');
     }
     
@@ -333,7 +345,11 @@ document.addEventListener('DOMContentLoaded', function() {
         timeline.innerHTML = '<div class="loading">Загрузка временной шкалы...</div>';
         
         try {
-            const response = await fetch('/api/recordings?channel=' + channelId + '&start=' + date + '&end=' + date);
+            let searchQuery = '/api/recordings?channel=' + channelId + '&start=' + date + '&end=' + date;
+            if (archiveEvent && archiveEvent.value) {
+                searchQuery += '&event=' + archiveEvent.value;
+            }
+            const response = await fetch(searchQuery);
             
             if (!response.ok) {
                 throw new Error('HTTP ' + response.status);
@@ -387,13 +403,24 @@ document.addEventListener('DOMContentLoaded', function() {
                     '<span class="recording-time">📅 ' + startTime + '</span>' +
                     '<span class="recording-duration">⏱️ ' + duration + '</span>' +
                     '<span class="recording-end">🏁 ' + endTime + '</span>' +
+                    (recording.RecordType ?
+                        '<span class="recording-type" style="color: ' + recordTypeColor(recording.RecordType) + '">● ' + recordTypeLabel(recording.RecordType) + '</span>' : '') +
+                    (recording.Size ? '<span class="recording-size">💾 ' + formatFileSize(recording.Size) + '</span>' : '') +
                 '</div>' +
                 '<div class="recording-actions">' +
-                    '<button class="play-btn primary-btn" onclick="playRecording(\'' + recording.StartTime + '\', \'' + recording.EndTime + '\', \'' + recording.Channel + '\')">' +
+                    '<button class="play-btn primary-btn">' +
                         '▶️ Воспроизвести' +
                     '</button>' +
+                    '<a class="download-btn secondary-btn" download href="/api/export?channel=' + encodeURIComponent(recording.Channel) +
+                        '&start=' + encodeURIComponent(recording.StartTime) + '&end=' + encodeURIComponent(recording.EndTime) + '">' +
+                        '⬇️ Скачать' +
+                    '</a>' +
                 '</div>';
             
+            recordingItem.querySelector('.play-btn').addEventListener('click', function() {
+                playRecording(recording.StartTime, recording.EndTime, recording.Channel, recording.PlaybackURI);
+            });
+            
             recordingsList.appendChild(recordingItem);
         });
     }
@@ -438,7 +465,7 @@ document.addEventListener('DOMContentLoaded', function() {
         const dayEnd = new Date(dateParts[2] + '-' + dateParts[1] + '-' + dateParts[0] + 'T23:59:59');
         const dayDuration = dayEnd - dayStart;
         
-        recordings.forEach(function(recording, index) {
+        recordings.forEach(function(recording) {
             const startTime = new Date(recording.StartTime);
             const endTime = new Date(recording.EndTime);
             
@@ -454,17 +481,18 @@ document.addEventListener('DOMContentLoaded', function() {
                 segment.style.width = Math.min(width, 100 - startPosition) + '%';
                 segment.style.height = '30px';
                 segment.style.top = '35px';
-                segment.style.background = 'hsl(' + ((index * 137.5) % 360) + ', 70%, 50%)';
+                segment.style.background = recordTypeColor(recording.RecordType);
                 segment.style.cursor = 'pointer';
                 segment.style.borderRadius = '2px';
                 segment.style.boxShadow = '0 1px 3px rgba(0,0,0,0.3)';
                 
                 // Добавляем всплывающую подсказку
-                segment.title = formatDateTime(recording.StartTime) + ' - ' + formatDateTime(recording.EndTime);
+                segment.title = formatDateTime(recording.StartTime) + ' - ' + formatDateTime(recording.EndTime) +
+                    (recording.RecordType ? ' (' + recordTypeLabel(recording.RecordType) + ')' : '');
                 
                 // Обработчик клика
                 segment.onclick = function() {
-                    playRecording(recording.StartTime, recording.EndTime, recording.Channel);
+                    playRecording(recording.StartTime, recording.EndTime, recording.Channel, recording.PlaybackURI);
                 };
                 
                 timelineContainer.appendChild(segment);
@@ -472,18 +500,92 @@ document.addEventListener('DOMContentLoaded', function() {
         });
         
         timeline.appendChild(timelineContainer);
+        timeline.appendChild(createRecordTypeLegend(recordings));
+    }
+    
+    /**
+     * Цвета и названия типов записей
+     */
+    const RECORD_TYPES = {
+        continuous: { label: 'Постоянная', color: '#4a90d9' },
+        motion: { label: 'Движение', color: '#f5a623' },
+        alarm: { label: 'Тревога', color: '#d0021b' },
+        event: { label: 'Событие', color: '#7b4fd0' }
+    };
+    
+    function recordTypeColor(type) {
+        return (RECORD_TYPES[type] || RECORD_TYPES.continuous).color;
+    }
+    
+    function recordTypeLabel(type) {
+        return RECORD_TYPES[type] ? RECORD_TYPES[type].label : type;
+    }
+    
+    /**
+     * Легенда временной шкалы для типов записей, найденных в результатах
+     */
+    function createRecordTypeLegend(recordings) {
+        const legend = document.createElement('div');
+        legend.className = 'timeline-legend';
+        legend.style.display = 'flex';
+        legend.style.gap = '15px';
+        legend.style.marginTop = '8px';
+        legend.style.fontSize = '12px';
+        
+        Object.keys(RECORD_TYPES).forEach(function(type) {
+            const present = recordings.some(function(recording) {
+                return (recording.RecordType || 'continuous') === type;
+            });
+            if (!present) {
+                return;
+            }
+            const item = document.createElement('span');
+            item.innerHTML = '<span style="display: inline-block; width: 10px; height: 10px; border-radius: 2px; margin-right: 4px; background: ' +
+                RECORD_TYPES[type].color + '"></span>' + RECORD_TYPES[type].label;
+            legend.appendChild(item);
+        });
+        
+        return legend;
+    }
+    
+    /**
+     * Форматирование размера файла
+     */
+    function formatFileSize(bytes) {
+        const units = ['Б', 'КБ', 'МБ', 'ГБ'];
+        let size = bytes;
+        let unit = 0;
+        while (size >= 1024 && unit < units.length - 1) {
+            size /= 1024;
+            unit++;
+        }
+        return size.toFixed(unit === 0 ? 0 : 1) + ' ' + units[unit];
     }
     
     /**
      * Воспроизведение архивной записи
      */
-    window.playRecording = async function(startTime, endTime, channelId) {
+    window.playRecording = async function(startTime, endTime, channelId, playbackURI) {
         showLoading('Загрузка архивной записи...');
         stopCurrentStream();
         
         try {
+            // Пробуем воспроизвести архив в браузере через WebRTC
+            try {
+                await startPlaybackWebRTCStream(channelId, startTime, endTime, playbackURI);
+                return;
+            } catch (webrtcError) {
+                console.warn('WebRTC воспроизведение архива недоступно:', webrtcError);
+                stopCurrentStream();
+            }
+            
             // Получаем URL для воспроизведения
-            const response = await fetch('/api/playback-url?channel=' + channelId + '&start=' + startTime + '&end=' + endTime);
+            let playbackQuery = '/api/playback-url?channel=' + channelId +
+                '&start=' + encodeURIComponent(startTime) + '&end=' + encodeURIComponent(endTime);
+            if (playbackURI) {
+                playbackQuery += '&uri=' + encodeURIComponent(playbackURI);
+            }
+            const response = await fetch(playbackQuery);
             
             if (!response.ok) {
                 throw new Error('HTTP ' + response.status);
@@ -521,6 +623,76 @@ document.addEventListener('DOMContentLoaded', function() {
         }
     };
     
+    /**
+     * Воспроизведение архива через WebRTC (временный поток go2rtc)
+     */
+    async function startPlaybackWebRTCStream(channelId, startTime, endTime, playbackURI) {
+        const videoElement = document.createElement('video');
+        videoElement.autoplay = true;
+        videoElement.playsInline = true;
+        videoElement.muted = true;
+        videoElement.controls = true;
+        videoElement.style.width = '100%';
+        videoElement.style.height = '100%';
+        videoElement.style.objectFit = 'contain';
+        
+        const pc = new RTCPeerConnection({
+            iceServers: [{ urls: 'stun:stun.l.google.com:19302' }]
+        });
+        currentRTCPeerConnection = pc;
+        
+        pc.ontrack = function(event) {
+            if (event.streams && event.streams[0]) {
+                videoElement.srcObject = event.streams[0];
+                currentStream = event.streams[0];
+                updateConnectionStatus('online');
+            }
+        };
+        
+        pc.addTransceiver('video', { direction: 'recvonly' });
+        
+        const offer = await pc.createOffer();
+        await pc.setLocalDescription(offer);
+        
+        const response = await fetch('/api/webrtc/offer/playback', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({
+                offer: { type: offer.type, sdp: offer.sdp },
+                channel: channelId,
+                start: startTime,
+                end: endTime,
+                uri: playbackURI || ''
+            })
+        });
+        
+        const answer = await response.json();
+        if (!response.ok || answer.error) {
+            throw new Error(answer.error || ('HTTP ' + response.status));
+        }
+        
+        currentPlaybackStreamId = answer.stream_id;
+        await pc.setRemoteDescription(new RTCSessionDescription({
+            type: answer.type || 'answer',
+            sdp: answer.sdp
+        }));
+        
+        videoContainer.innerHTML = '';
+        videoContainer.appendChild(videoElement);
+        currentVideoElement = videoElement;
+        
+        const infoPanel = document.createElement('div');
+        infoPanel.className = 'video-info-panel';
+        infoPanel.innerHTML = 
+            '<div class="video-info">' +
+                '<span>📺 Канал ' + channelId + '</span>' +
+                '<span>📼 ' + formatDateTime(startTime) + ' - ' + formatDateTime(endTime) + '</span>' +
+            '</div>';
+        videoContainer.appendChild(infoPanel);
+    }
+    
     /**
      * Копирование в буфер обмена
      */
@@ -611,6 +783,114 @@ document.addEventListener('DOMContentLoaded', function() {
         }
     }
     
+    // Названия событий устройств
+    const EVENT_LABELS = {
+        motion: 'Движение',
+        linedetection: 'Пересечение линии',
+        fielddetection: 'Вторжение в зону',
+        alarm: 'Тревожный вход',
+        tamper: 'Закрытие объектива',
+        videoloss: 'Потеря видеосигнала',
+        diskfull: 'Диск заполнен',
+        diskerror: 'Ошибка диска',
+        deviceoffline: 'Устройство недоступно',
+        go2rtcdown: 'Сбой go2rtc'
+    };
+    
+    // Сколько последних событий показывать
+    const MAX_EVENTS = 20;
+    
+    // Запас записи до и после события при просмотре архива (мс)
+    const EVENT_PLAYBACK_BEFORE = 15000;
+    const EVENT_PLAYBACK_AFTER = 30000;
+    
+    /**
+     * Загрузка последних событий из журнала
+     */
+    async function loadEventHistory() {
+        // Журнал событий доступен только с правом на архив
+        if (!eventsList || eventsList.dataset.history !== 'true') {
+            return;
+        }
+        
+        try {
+            const response = await fetch('/api/events/history?limit=' + MAX_EVENTS);
+            if (!response.ok) {
+                return;
+            }
+            const data = await response.json();
+            // Ответ от новых к старым, а showDeviceEvent добавляет в начало списка
+            data.events.slice().reverse().forEach(function(event) {
+                showDeviceEvent({
+                    type: event.type,
+                    state: event.active ? 'active' : 'inactive',
+                    channel: event.channel,
+                    device: event.device,
+                    time: event.start,
+                    playbackStart: event.playback_start,
+                    playbackEnd: event.playback_end
+                });
+            });
+        } catch (error) {
+            console.error('❌ Ошибка загрузки журнала событий:', error);
+        }
+    }
+    
+    /**
+     * Подписка на события устройств (Server-Sent Events)
+     */
+    function subscribeEvents() {
+        if (!eventsList || !window.EventSource) {
+            return;
+        }
+        
+        const source = new EventSource('/api/events');
+        source.onmessage = function(message) {
+            try {
+                showDeviceEvent(JSON.parse(message.data));
+            } catch (error) {
+                console.error('❌ Ошибка разбора события:', error);
+            }
+        };
+        // При обрыве EventSource переподключается сам
+    }
+    
+    /**
+     * Добавление события в список. Клик по событию канала открывает
+     * запись архива вокруг события.
+     */
+    function showDeviceEvent(event) {
+        if (eventsList.querySelector('p')) {
+            eventsList.innerHTML = '';
+        }
+        
+        const item = document.createElement('div');
+        item.className = 'event-item';
+        item.style.padding = '4px 0';
+        item.style.borderBottom = '1px solid #e9ecef';
+        
+        const label = EVENT_LABELS[event.type] || event.type;
+        const source = event.channel ? 'канал ' + event.channel : 'устройство ' + event.device;
+        const state = event.state === 'inactive' ? ' (завершено)' : '';
+        item.textContent = formatDateTime(event.time) + ' - ' + label + state + ', ' + source;
+        
+        if (event.channel) {
+            const time = new Date(event.time).getTime();
+            const start = event.playbackStart || new Date(time - EVENT_PLAYBACK_BEFORE).toISOString();
+            const end = event.playbackEnd || new Date(time + EVENT_PLAYBACK_AFTER).toISOString();
+            item.style.cursor = 'pointer';
+            item.title = 'Открыть запись';
+            item.addEventListener('click', function() {
+                playRecording(start, end, event.channel);
+            });
+        }
+        
+        eventsList.insertBefore(item, eventsList.firstChild);
+        while (eventsList.children.length > MAX_EVENTS) {
+            eventsList.removeChild(eventsList.lastChild);
+        }
+    }
+    
     /**
      * Инициализация обработчиков событий
      */
@@ -653,6 +933,9 @@ document.addEventListener('DOMContentLoaded', function() {
         // Инициализируем обработчики
         initEventHandlers();
         
+        // Загружаем журнал и подписываемся на события устройств
+        loadEventHistory().then(subscribeEvents);
+        
         // Периодическая проверка статуса
         setInterval(checkSystemStatus, 30000);
         